@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"path/filepath"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	mimeSniffLength          = 3072
+	storedObjectCacheControl = "private, max-age=31536000, immutable" // the stored objects never change
+)
+
+// inlineMimeTypes are the types served inline, the raster images, audio and
+// video the clients play in place. The other files are served as downloads.
+var inlineMimeTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+
+	"audio/mpeg":  true,
+	"audio/ogg":   true,
+	"audio/wav":   true,
+	"audio/webm":  true,
+	"audio/mp4":   true,
+	"audio/x-m4a": true,
+	"audio/aac":   true,
+
+	"video/mp4":       true,
+	"video/webm":      true,
+	"video/ogg":       true,
+	"video/quicktime": true,
+}
+
+type AttachmentResource struct {
+	ID       UUID   `json:"id"`
+	FileName string `json:"file_name"`
+	MimeType string `json:"mime_type"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"`
+	URL      string `json:"url"`
+
+	Processing       bool                `json:"processing"`        // true until the image is processed
+	ProcessingFailed bool                `json:"processing_failed"` // the image could not be processed, only its uploader can download it
+	Width            *int                `json:"width,omitempty"`
+	Height           *int                `json:"height,omitempty"`
+	Blurhash         *string             `json:"blurhash,omitempty"`
+	Thumbnails       []ThumbnailResource `json:"thumbnails,omitempty"`
+
+	DurationMs *int64 `json:"duration_ms,omitempty"`
+	Waveform   []int  `json:"waveform,omitempty"` // from 0 to 255
+	// what the waveform is computed from, "amplitude" or "packet_size"
+	WaveformSource string `json:"waveform_source,omitempty"`
+}
+
+type ThumbnailResource struct {
+	Label    string `json:"label"`
+	MimeType string `json:"mime_type"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	URL      string `json:"url"`
+}
+
+func newAttachmentResource(a *Attachment) *AttachmentResource {
+	url := "/api/v1/chat/attachments/" + a.ID.String()
+	out := &AttachmentResource{
+		ID:               a.ID,
+		FileName:         a.FileName,
+		MimeType:         a.MimeType,
+		Size:             a.Size,
+		Checksum:         a.Checksum,
+		URL:              url,
+		Processing:       a.NeedsImageProcessing(),
+		ProcessingFailed: a.ProcessingFailed(),
+		Width:            a.Width,
+		Height:           a.Height,
+		Blurhash:         a.Blurhash,
+		DurationMs:       a.DurationMs,
+	}
+	for _, v := range a.Waveform {
+		out.Waveform = append(out.Waveform, int(v))
+	}
+	if len(out.Waveform) > 0 {
+		out.WaveformSource = waveformSources[a.MimeType]
+	}
+	for _, v := range a.Thumbnails {
+		out.Thumbnails = append(out.Thumbnails, ThumbnailResource{
+			Label:    v.Label,
+			MimeType: v.MimeType,
+			Width:    v.Width,
+			Height:   v.Height,
+			URL:      url + "/thumbnails/" + v.Label,
+		})
+	}
+	return out
+}
+
+type CreateAttachmentInput struct {
+	Ctx      context.Context // bounds the copy to the storage, large files take longer than ctx() allows
+	U        *User
+	FileName string
+	Reader   io.Reader
+	Size     int64
+}
+
+// CreateAttachment stores the file in the storage backend and records it. The
+// MIME type is sniffed from the content, the name sent by the client is only
+// used for display.
+func CreateAttachment(in *CreateAttachmentInput) (*Attachment, error) {
+	attachment, err := storeAttachment(in)
+	if err != nil {
+		return nil, err
+	}
+	if err := DB().Create(attachment).Error; err != nil {
+		Storage().Delete(ctx(), attachment.StorageKey)
+		return nil, err
+	}
+	if attachment.NeedsImageProcessing() {
+		enqueueImageProcessing(attachment.ID)
+	}
+	return attachment, nil
+}
+
+// storeAttachment copies the file to the storage backend and returns the
+// attachment to record, the caller creates the row.
+func storeAttachment(in *CreateAttachmentInput) (*Attachment, error) {
+	head := make([]byte, mimeSniffLength)
+	n, err := io.ReadFull(in.Reader, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	head = head[:n]
+	if n == 0 {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "file is empty")
+	}
+	mimeType, _, _ := mime.ParseMediaType(mimetype.Detect(head).String())
+
+	attachment := &Attachment{
+		ID:           NewUUIDv4(),
+		UploadedByID: in.U.ID,
+		FileName:     sanitizeFileName(in.FileName),
+		MimeType:     mimeType,
+	}
+	attachment.StorageKey = "attachments/" + attachment.ID.String()
+
+	hasher := sha256.New()
+	counter := &countingWriter{}
+	writers := []io.Writer{hasher, counter}
+	// voice notes are small, they are analyzed while they are uploaded
+	var audio *bytes.Buffer
+	if isAnalyzableAudio(mimeType) && in.Size >= 0 && in.Size <= maxAnalyzedAudioSize {
+		audio = bytes.NewBuffer(make([]byte, 0, in.Size))
+		writers = append(writers, audio)
+	}
+	reader := io.TeeReader(io.MultiReader(bytes.NewReader(head), in.Reader), io.MultiWriter(writers...))
+	if err := Storage().Put(in.Ctx, attachment.StorageKey, reader, in.Size, mimeType); err != nil {
+		return nil, err
+	}
+	if in.Size >= 0 && counter.n != in.Size {
+		Storage().Delete(ctx(), attachment.StorageKey)
+		return nil, fiber.NewError(fiber.StatusBadRequest, "file size does not match the uploaded content")
+	}
+	attachment.Size = counter.n
+	attachment.Checksum = hex.EncodeToString(hasher.Sum(nil))
+	if audio != nil {
+		// an audio file that can not be analyzed is still a valid attachment
+		if info, err := analyzeAudio(audio.Bytes(), mimeType); err == nil {
+			attachment.DurationMs = &info.durationMs
+			attachment.Waveform = info.waveform
+		} else {
+			AppLogger.WithError(err).Warn("failed to analyze audio attachment")
+		}
+	}
+	return attachment, nil
+}
+
+type countingWriter struct{ n int64 }
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+func sanitizeFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "" || name == "." || name == "/" {
+		name = "file"
+	}
+	if runes := []rune(name); len(runes) > 255 {
+		name = string(runes[len(runes)-255:])
+	}
+	return name
+}
+
+func UploadAttachment(c *fiber.Ctx, u *User) (*AttachmentResource, error) {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusUnprocessableEntity, "file is required")
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	attachment, err := CreateAttachment(&CreateAttachmentInput{
+		Ctx:      c.Context(),
+		U:        u,
+		FileName: fileHeader.Filename,
+		Reader:   file,
+		Size:     fileHeader.Size,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newAttachmentResource(attachment), nil
+}
+
+// getAttachmentForUser returns the attachment if the user uploaded it, or if
+// it is carried by a message of a room the user is a member of. Play once
+// voice notes are no longer available to the members who played them.
+func getAttachmentForUser(tx *gorm.DB, attachmentID string, u *User) (*Attachment, error) {
+	if _, err := UUIDFromString(attachmentID); err != nil {
+		return nil, fiber.NewError(fiber.StatusUnprocessableEntity, "invalid attachment_id")
+	}
+	attachment := &Attachment{}
+	if err := tx.Where("id = ?", attachmentID).
+		Where(tx.Where("uploaded_by_id = ?", u.ID).
+			Or(`EXISTS (SELECT 1 FROM "chat_messages" JOIN "chat_rooms" ON "chat_rooms"."id" = "chat_messages"."chat_room_id" WHERE "chat_messages"."attachment_id" = "attachments"."id" AND "chat_messages"."deleted_at" IS NULL AND "chat_rooms"."deleted_at" IS NULL AND ? = ANY("chat_rooms"."users_ids") AND NOT ("chat_messages"."play_once" AND EXISTS (SELECT 1 FROM "chat_message_plays" WHERE "chat_message_plays"."chat_message_id" = "chat_messages"."id" AND "chat_message_plays"."user_id" = ?)))`, u.ID, u.ID)).
+		First(attachment).Error; err != nil &&
+		!errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if attachment.ID.IsEmpty() {
+		return nil, fiber.NewError(fiber.StatusNotFound,
+			"attachment not found, or you are not a member of the room it was sent to")
+	}
+	return attachment, nil
+}
+
+func DownloadAttachment(c *fiber.Ctx, u *User, attachmentID string) error {
+	attachment, err := getAttachmentForUser(DB(), attachmentID, u)
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) && fiberErr.Code == fiber.StatusNotFound {
+		if played, playedErr := hasPlayedOnce(u, attachmentID); playedErr == nil && played {
+			return fiber.NewError(fiber.StatusGone, "this voice note can only be played once")
+		}
+	}
+	if err != nil {
+		return err
+	}
+	// the metadata of the image is only stripped once it is processed
+	if attachment.NeedsImageProcessing() && attachment.UploadedByID != u.ID {
+		return fiber.NewError(fiber.StatusConflict, "attachment is still being processed")
+	}
+	if attachment.ProcessingFailed() && attachment.UploadedByID != u.ID {
+		return fiber.NewError(fiber.StatusForbidden, "the image could not be processed, it is only available to its uploader")
+	}
+	playedOnce, err := recordPlayOnceDownload(u, attachment.ID)
+	if err != nil {
+		return err
+	}
+	if playedOnce {
+		// neither the browser nor a proxy may keep a copy to play it again
+		return sendStoredObject(c, attachment.StorageKey, attachment.MimeType, attachment.Size, attachment.FileName, "", "no-store")
+	}
+	return sendStoredObject(c, attachment.StorageKey, attachment.MimeType, attachment.Size, attachment.FileName, attachment.Checksum, storedObjectCacheControl)
+}
+
+func DownloadAttachmentThumbnail(c *fiber.Ctx, u *User, attachmentID, label string) error {
+	attachment, err := getAttachmentForUser(DB(), attachmentID, u)
+	if err != nil {
+		return err
+	}
+	thumbnail := &AttachmentThumbnail{}
+	if err := DB().Where("attachment_id = ?", attachment.ID).
+		Where("label = ?", label).
+		First(thumbnail).Error; err != nil &&
+		!errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if thumbnail.StorageKey == "" {
+		return fiber.NewError(fiber.StatusNotFound, "thumbnail not found")
+	}
+	return sendStoredObject(c, thumbnail.StorageKey, thumbnail.MimeType, thumbnail.Size, thumbnail.Label+"_"+attachment.FileName, "", storedObjectCacheControl)
+}
+
+func sendStoredObject(c *fiber.Ctx, key, mimeType string, size int64, fileName, checksum, cacheControl string) error {
+	// the stream is read after the handler returns, it must not be bound to
+	// the deadline of ctx() that a large video outlives
+	reader, err := Storage().Get(c.Context(), key)
+	if errors.Is(err, ErrObjectNotFound) {
+		return fiber.NewError(fiber.StatusNotFound, "file not found")
+	}
+	if err != nil {
+		return err
+	}
+	// only the media the browsers can not run as a document are shown inline,
+	// anything else, e.g. HTML or SVG, would run on the origin of the API
+	disposition := "inline"
+	if !inlineMimeTypes[mimeType] {
+		disposition = "attachment"
+		mimeType = "application/octet-stream"
+	}
+	c.Set(fiber.HeaderContentType, mimeType)
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	if header := mime.FormatMediaType(disposition, map[string]string{"filename": fileName}); header != "" {
+		c.Set(fiber.HeaderContentDisposition, header)
+	} else {
+		c.Set(fiber.HeaderContentDisposition, disposition)
+	}
+	c.Set(fiber.HeaderCacheControl, cacheControl)
+	if checksum != "" {
+		c.Set(fiber.HeaderETag, `"`+checksum+`"`)
+	}
+	return c.SendStream(reader, int(size))
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -103,6 +104,23 @@ func GetRoomsByUserID(c *fiber.Ctx, u *User) (*PaginatedData[ChatRoomResource],
 		otherUsersIndexed[v.ID.String()] = v
 	}
 
+	roomsIds := []UUID{}
+	for _, v := range o.Data {
+		roomsIds = append(roomsIds, v.ID)
+	}
+	unreadCounts, err := unreadCountsByRoom(u, roomsIds)
+	if err != nil {
+		return nil, err
+	}
+	pinnedCounts, err := pinnedCountsByRoom(DB(), roomsIds)
+	if err != nil {
+		return nil, err
+	}
+	drafts, err := loadRoomsDrafts(u, roomsIds)
+	if err != nil {
+		return nil, err
+	}
+
 	newO, err := TransformPaginatedData(o, func(data ChatRoom) (ChatRoomResource, error) {
 		isPrivate := len(data.UsersIDs) == 2 && *data.PeerToPeer
 		crType := RTGroup
@@ -121,20 +139,20 @@ func GetRoomsByUserID(c *fiber.Ctx, u *User) (*PaginatedData[ChatRoomResource],
 			name = otherUser.Name
 		}
 		var lastMessage *SentMessageResource
-		if data.LatestMessage != nil {
-			msg := data.LatestMessage
-			isMyMessage := msg.CreatedByID == u.ID
-			lastMessage = &SentMessageResource{
-				ID:        msg.ID,
-				Content:   msg.Content,
-				Type:      msg.Type,
-				SentAt:    msg.CreatedAt,
-				EditedAt:  msg.EditedAt,
-				MyMassage: isMyMessage,
-				SenderID:  &msg.CreatedByID,
-			}
-			if user := msg.CreatedBy; user != nil {
-				lastMessage.User = *user
+		if msg := data.LatestMessage; msg != nil {
+			resource := newSentMessageResource(msg, nil, u.ID.String())
+			lastMessage = &resource
+		}
+		var adminIds []string
+		if !isPrivate {
+			adminIds = data.AdminsIDs
+		}
+		var draft *DraftResource
+		if v, ok := drafts[data.ID.String()]; ok {
+			draft = &DraftResource{
+				RoomID:    v.ChatRoomID,
+				Content:   v.Content,
+				UpdatedAt: v.UpdatedAt,
 			}
 		}
 		return ChatRoomResource{
@@ -143,8 +161,13 @@ func GetRoomsByUserID(c *fiber.Ctx, u *User) (*PaginatedData[ChatRoomResource],
 			CreatedAt:     data.CreatedAt,
 			Name:          name,
 			NumberOfUsers: numberOfUsers,
+			AdminIDs:      adminIds,
 			OtherUser:     otherUser,
 			LastMessage:   lastMessage,
+			UnreadCount:   IntVar(unreadCounts[data.ID.String()]),
+			MessageTTL:    data.MessageTTL,
+			PinnedCount:   IntVar(pinnedCounts[data.ID.String()]),
+			Draft:         draft,
 		}, nil
 	})
 	if err != nil {
@@ -200,19 +223,8 @@ func CreateGroupRoom(in *CreateGroupRoomInput) (any, error) {
 	if roomAlreadyExists {
 		var lastMessage *SentMessageResource
 		if msg := room.LatestMessage; msg != nil {
-			isMyMessage := msg.CreatedByID == in.U.ID
-			lastMessage = &SentMessageResource{
-				ID:        msg.ID,
-				Content:   msg.Content,
-				Type:      msg.Type,
-				SentAt:    msg.CreatedAt,
-				EditedAt:  msg.EditedAt,
-				MyMassage: isMyMessage,
-				SenderID:  &msg.CreatedByID,
-			}
-			if user := msg.CreatedBy; user != nil {
-				lastMessage.User = *user
-			}
+			resource := newSentMessageResource(msg, nil, in.U.ID.String())
+			lastMessage = &resource
 		}
 		return ChatRoomResource{
 			RoomID:        room.ID,
@@ -226,6 +238,7 @@ func CreateGroupRoom(in *CreateGroupRoomInput) (any, error) {
 	}
 	room = &ChatRoom{
 		UsersIDs:   allRoomUsersIds,
+		AdminsIDs:  StringArray{in.U.ID.String()},
 		Name:       in.Name,
 		UsersLimit: 99,
 		PeerToPeer: BoolVar(false),
@@ -239,6 +252,7 @@ func CreateGroupRoom(in *CreateGroupRoomInput) (any, error) {
 		Type:          RTGroup,
 		NumberOfUsers: IntVar(len(allRoomUsersIds)),
 		UserIDs:       allRoomUsersIds,
+		AdminIDs:      room.AdminsIDs,
 		CreatedAt:     room.CreatedAt,
 	}, nil
 }
@@ -262,42 +276,97 @@ func GetRoomMessages(c *fiber.Ctx, u *User, roomID string) (*PaginatedData[SentM
 			"room not found, or you are not a member of this room")
 	}
 
-	txx := tx.Where("chat_room_id = ?", roomID)
+	txx := tx.Where("chat_room_id = ?", roomID).
+		Where("chat_messages.deleted_at IS NULL")
 
-	o, err := Paginate(c, ChatMessage{}, txx, func(tx *gorm.DB) *gorm.DB {
+	o, err := PaginateWithCursor(c, ChatMessage{}, txx, messagesCursorKey, func(tx *gorm.DB) *gorm.DB {
 		// return tx.Joins("CreatedBy", DB().Where(`"CreatedBy".id <> ?`, u.ID)).Order("created_at DESC")
-		return tx.Joins("CreatedBy").Order("created_at DESC")
+		return preloadMessageContent(tx.Joins("CreatedBy")).Preload("ReplyTo.CreatedBy").Order("created_at DESC")
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	newO, err := TransformPaginatedData(o, func(data ChatMessage) (SentMessageResource, error) {
-		isMyMessage := data.CreatedByID == u.ID
-		return SentMessageResource{
-			ID:        data.ID,
-			Content:   data.Content,
-			Type:      data.Type,
-			SentAt:    data.CreatedAt,
-			EditedAt:  data.EditedAt,
-			MyMassage: isMyMessage,
-			SenderID:  &data.CreatedByID,
-			RoomID:    &data.ChatRoomID,
-			User:      *data.CreatedBy,
-		}, nil
-	})
+	return transformMessagesPage(o, u)
+}
+
+// preloadMessageContent loads what a message carries besides its text, its
+// attachment, location or poll.
+func preloadMessageContent(tx *gorm.DB) *gorm.DB {
+	return tx.Preload("Attachment.Thumbnails").
+		Preload("Location").
+		Preload("ForwardedFromUser").
+		Preload("Poll.Options", func(tx *gorm.DB) *gorm.DB { return tx.Order("position ASC") })
+}
+
+// transformMessagesPage builds the resources of a page of messages as seen by
+// the user, along with the data that is loaded in batch for the whole page.
+// The messages must be loaded with their CreatedBy.
+func transformMessagesPage(o *PaginatedData[ChatMessage], u *User) (*PaginatedData[SentMessageResource], error) {
+	messagesIds := []uint{}
+	for _, v := range o.Data {
+		messagesIds = append(messagesIds, v.ID)
+	}
+	reactions, err := loadMessagesReactions(messagesIds)
+	if err != nil {
+		return nil, err
+	}
+	mentions, err := loadMessagesMentions(messagesIds)
+	if err != nil {
+		return nil, err
+	}
+	voiceNotesIds := []uint{}
+	for _, v := range o.Data {
+		if v.Type == CMTypeAudio {
+			voiceNotesIds = append(voiceNotesIds, v.ID)
+		}
+	}
+	plays, err := loadMessagesPlays(voiceNotesIds)
+	if err != nil {
+		return nil, err
+	}
+	pollsIds := []uint{}
+	for _, v := range o.Data {
+		if v.Poll != nil {
+			pollsIds = append(pollsIds, v.ID)
+		}
+	}
+	votes, err := loadPollsVotes(pollsIds)
+	if err != nil {
+		return nil, err
+	}
+	stars, err := loadMessagesStars(messagesIds, u)
 	if err != nil {
 		return nil, err
 	}
 
-	return newO, nil
+	return TransformPaginatedData(o, func(data ChatMessage) (SentMessageResource, error) {
+		resource := newSentMessageResource(&data, nil, u.ID.String())
+		resource.Reactions = summarizeReactions(reactions[data.ID], u.ID.String())
+		resource.Mentions = summarizeMentions(mentions[data.ID])
+		if data.Type == CMTypeAudio {
+			resource.PlayedBy = playedByUsersIDs(plays[data.ID])
+		}
+		if data.Poll != nil {
+			resource.Poll = newPollResource(data.Poll, votes[data.ID], u.ID.String())
+		}
+		_, resource.Starred = stars[data.ID]
+		return resource, nil
+	})
 }
 
 type SendMessageInput struct {
-	U           *User
-	OtherUserID string
-	RoomID      string
-	Content     string
+	U            *User
+	OtherUserID  string
+	RoomID       string
+	Content      string
+	ReplyToID    *uint
+	AttachmentID string
+	Location     *LocationPayload // already validated
+	PlayOnce     bool
+	TTLSeconds   *int         // overrides the message_ttl of the room, 0 for a message that never disappears
+	Poll         *PollPayload // already validated
+	ForwardOf    *ChatMessage // the message forwarded, loaded with its content and already checked
 }
 
 func SendMessageSync(in *SendMessageInput) error {
@@ -305,39 +374,98 @@ func SendMessageSync(in *SendMessageInput) error {
 	if err != nil {
 		return err
 	}
+	broadcastNewMessage(newMessageOut, in.U)
+	return nil
+}
+
+// broadcastNewMessage notifies the room members of a newly created message,
+// and of the room itself if it was created along with the message.
+func broadcastNewMessage(newMessageOut *newMessageOutput, sender *User) {
 	room := newMessageOut.Room
 	msg := newMessageOut.Message
-	currentUserId := in.U.ID.String()
+	currentUserId := sender.ID.String()
+
 	usersIds := []string{currentUserId}
 	for _, id := range room.UsersIDs {
 		if id != currentUserId {
 			usersIds = append(usersIds, id)
 		}
 	}
-	BroadcastWSMassage(usersIds, func(userId string) WSClientEventMessage {
-		isMyMessage := userId == currentUserId
-		return WSClientEventMessage{
-			Type: WSMessageEvent,
-			DataModel: SentMessageResource{
-				ID:        msg.ID,
-				Content:   msg.Content,
-				Type:      msg.Type,
-				SentAt:    msg.CreatedAt,
-				EditedAt:  msg.EditedAt,
-				MyMassage: isMyMessage,
-				SenderID:  &msg.CreatedByID,
-				RoomID:    &msg.ChatRoomID,
-				User:      *in.U,
-			},
+
+	if newMessageOut.NewRoomCreated {
+		crType := RTGroup
+		isPrivate := len(room.UsersIDs) == 2 && *room.PeerToPeer
+		if isPrivate {
+			crType = RTPrivate
 		}
-	})
-	return nil
+		BroadcastWSMassage(usersIds, func(userId string) WSClientEventMessage {
+			isMyMessage := userId == currentUserId
+			lastMessage := newSentMessageResource(&msg, sender, userId)
+			lastMessage.Mentions = summarizeMentions(newMessageOut.Mentions)
+
+			model := ChatRoomResource{
+				RoomID:        room.ID,
+				Type:          crType,
+				Name:          room.Name,
+				UserIDs:       room.UsersIDs,
+				CreatedAt:     room.CreatedAt,
+				NumberOfUsers: IntVar(len(room.UsersIDs)),
+				LastMessage:   &lastMessage,
+			}
+			if isPrivate {
+				otherUser := newMessageOut.OtherUser
+
+				if isMyMessage {
+					model.Name = sender.Name
+					model.OtherUser = otherUser
+				} else {
+					model.Name = otherUser.Name
+					model.OtherUser = sender
+				}
+			}
+			return WSClientEventMessage{
+				Type:      WSNewRoomEvent,
+				DataModel: model,
+			}
+		})
+	}
+
+	go func() {
+		// delay the message broadcast if the room is new
+		if newMessageOut.NewRoomCreated {
+			<-time.After(500 * time.Millisecond)
+		}
+
+		BroadcastWSMassage(usersIds, func(userId string) WSClientEventMessage {
+			resource := newSentMessageResource(&msg, sender, userId)
+			resource.Mentions = summarizeMentions(newMessageOut.Mentions)
+			return WSClientEventMessage{
+				Type:      WSMessageEvent,
+				DataModel: resource,
+			}
+		})
+
+		broadcastMentions(&msg, sender, newMessageOut.Mentions)
+	}()
 }
 
 type SSEType string
 
 const (
-	SSEMessageEvent SSEType = "message"
+	SSEMessageEvent     SSEType = "message"
+	SSETypingStartEvent SSEType = "typing_start"
+	SSETypingStopEvent  SSEType = "typing_stop"
+	SSEReadEvent        SSEType = "read"
+	SSEAckEvent         SSEType = "ack"
+	SSEEditMessageEvent SSEType = "edit_message"
+	SSEDelMessageEvent  SSEType = "delete_message"
+	SSEReactionEvent    SSEType = "reaction"
+
+	SSELiveLocationStartEvent  SSEType = "live_location_start"
+	SSELiveLocationUpdateEvent SSEType = "live_location_update"
+	SSELiveLocationStopEvent   SSEType = "live_location_stop"
+	SSEPollVoteEvent           SSEType = "poll_vote"
+	SSEDraftEvent              SSEType = "draft"
 )
 
 type SocketSentEvent struct {
@@ -346,127 +474,297 @@ type SocketSentEvent struct {
 }
 
 type SSEMessage struct {
-	OtherUserID string `json:"other_user_id"`
-	ChatRoomID  string `json:"room_id"`
-	Content     string `json:"content"`
+	OtherUserID  string           `json:"other_user_id"`
+	ChatRoomID   string           `json:"room_id"`
+	Content      string           `json:"content"`
+	ReplyToID    *uint            `json:"reply_to_id"`
+	AttachmentID string           `json:"attachment_id"`
+	Location     *LocationPayload `json:"location"`
+	PlayOnce     bool             `json:"play_once"`
+	TTLSeconds   *int             `json:"ttl_seconds"`
+	Poll         *PollPayload     `json:"poll"`
 }
 
-func ReceiveWSEvent(currentUser *User, data []byte) error {
+type SSEAck struct {
+	EventsIDs []uint64 `json:"event_ids"`
+}
+
+type SSEEditMessage struct {
+	MessageID uint   `json:"message_id"`
+	Content   string `json:"content"`
+}
+
+type SSEDeleteMessage struct {
+	MessageID uint `json:"message_id"`
+}
+
+type SSEReaction struct {
+	MessageID uint   `json:"message_id"`
+	Emoji     string `json:"emoji"`
+	Action    string `json:"action"` // add or remove
+}
+
+type SSERead struct {
+	ChatRoomID string `json:"room_id"`
+	MessageID  uint   `json:"message_id"`
+}
+
+func ReceiveWSEvent(clientConn *WSClientSocket, data []byte) error {
 	var sse SocketSentEvent
 	if err := json.Unmarshal(data, &sse); err != nil {
 		return err
 	}
 
-	currentUserId := currentUser.ID.String()
+	currentUser := clientConn.user
 
 	switch sse.Event {
+	case SSEAckEvent:
+		var sseData SSEAck
+		if err := json.Unmarshal(sse.Data, &sseData); err != nil {
+			return err
+		}
+		clientConn.Ack(sseData.EventsIDs...)
+		return nil
+	case SSETypingStartEvent, SSETypingStopEvent:
+		var sseData SSETyping
+		if err := json.Unmarshal(sse.Data, &sseData); err != nil {
+			return err
+		}
+		return handleTypingEvent(clientConn, sse.Event, sseData)
+	case SSELiveLocationStartEvent, SSELiveLocationUpdateEvent, SSELiveLocationStopEvent:
+		var sseData SSELiveLocation
+		if err := json.Unmarshal(sse.Data, &sseData); err != nil {
+			return err
+		}
+		return handleLiveLocationEvent(clientConn, sse.Event, sseData)
+	case SSEReadEvent:
+		var sseData SSERead
+		if err := json.Unmarshal(sse.Data, &sseData); err != nil {
+			return err
+		}
+		_, err := MarkRoomAsRead(&MarkRoomAsReadInput{
+			U:         currentUser,
+			RoomID:    sseData.ChatRoomID,
+			MessageID: sseData.MessageID,
+		})
+		return err
+	case SSEEditMessageEvent:
+		var sseData SSEEditMessage
+		if err := json.Unmarshal(sse.Data, &sseData); err != nil {
+			return err
+		}
+		if err := ValidateVar("content", sseData.Content, "required,max=255"); err != nil {
+			return err
+		}
+		_, err := EditMessage(&EditMessageInput{
+			U:         currentUser,
+			MessageID: sseData.MessageID,
+			Content:   sseData.Content,
+		})
+		return err
+	case SSEDelMessageEvent:
+		var sseData SSEDeleteMessage
+		if err := json.Unmarshal(sse.Data, &sseData); err != nil {
+			return err
+		}
+		_, err := DeleteMessage(&DeleteMessageInput{
+			U:         currentUser,
+			MessageID: sseData.MessageID,
+		})
+		return err
+	case SSEPollVoteEvent:
+		var sseData SSEPollVote
+		if err := json.Unmarshal(sse.Data, &sseData); err != nil {
+			return err
+		}
+		if err := ValidateVar("option_ids", sseData.OptionIDs, "unique,dive,gt=0"); err != nil {
+			return err
+		}
+		_, err := VotePoll(&VotePollInput{
+			U:         currentUser,
+			MessageID: sseData.MessageID,
+			OptionIDs: sseData.OptionIDs,
+		})
+		return err
+	case SSEDraftEvent:
+		var sseData SSEDraft
+		if err := json.Unmarshal(sse.Data, &sseData); err != nil {
+			return err
+		}
+		if err := ValidateVar("content", sseData.Content, "max=255"); err != nil {
+			return err
+		}
+		return handleDraftEvent(clientConn, sseData)
+	case SSEReactionEvent:
+		var sseData SSEReaction
+		if err := json.Unmarshal(sse.Data, &sseData); err != nil {
+			return err
+		}
+		if err := ValidateVar("emoji", sseData.Emoji, "required,max=32"); err != nil {
+			return err
+		}
+		action := ReactionAdded
+		if sseData.Action == "remove" {
+			action = ReactionRemoved
+		}
+		_, err := ReactToMessage(&ReactToMessageInput{
+			U:         currentUser,
+			MessageID: sseData.MessageID,
+			Emoji:     sseData.Emoji,
+			Action:    action,
+		})
+		return err
 	case SSEMessageEvent:
 		var sseData SSEMessage
 		if err := json.Unmarshal(sse.Data, &sseData); err != nil {
 			return err
 		}
+		if sseData.Location != nil {
+			if err := Validate(sseData.Location); err != nil {
+				return err
+			}
+		}
+		if sseData.Poll != nil {
+			if err := Validate(sseData.Poll); err != nil {
+				return err
+			}
+		}
 		newMessageOut, err := createNewMessage(&SendMessageInput{
-			U:           currentUser,
-			OtherUserID: sseData.OtherUserID,
-			RoomID:      sseData.ChatRoomID,
-			Content:     sseData.Content,
+			U:            currentUser,
+			OtherUserID:  sseData.OtherUserID,
+			RoomID:       sseData.ChatRoomID,
+			Content:      sseData.Content,
+			ReplyToID:    sseData.ReplyToID,
+			AttachmentID: sseData.AttachmentID,
+			Location:     sseData.Location,
+			PlayOnce:     sseData.PlayOnce,
+			TTLSeconds:   sseData.TTLSeconds,
+			Poll:         sseData.Poll,
 		})
 		if err != nil {
 			return err
 		}
 		room := newMessageOut.Room
-		msg := newMessageOut.Message
 
-		usersIds := []string{currentUserId}
-		for _, id := range room.UsersIDs {
-			if id != currentUserId {
-				usersIds = append(usersIds, id)
-			}
-		}
+		// a sent message ends the typing state of the sender
+		delete(clientConn.lastTypingAt, room.ID.String())
+		typingTracker.Stop(room, currentUser)
 
-		if newMessageOut.NewRoomCreated {
-			crType := RTGroup
-			isPrivate := len(room.UsersIDs) == 2 && *room.PeerToPeer
-			if isPrivate {
-				crType = RTPrivate
-			}
-			BroadcastWSMassage(usersIds, func(userId string) WSClientEventMessage {
-				isMyMessage := userId == currentUserId
-
-				model := ChatRoomResource{
-					RoomID:        room.ID,
-					Type:          crType,
-					Name:          room.Name,
-					UserIDs:       room.UsersIDs,
-					CreatedAt:     room.CreatedAt,
-					NumberOfUsers: IntVar(len(room.UsersIDs)),
-					LastMessage: &SentMessageResource{
-						ID:        msg.ID,
-						Content:   msg.Content,
-						Type:      msg.Type,
-						SentAt:    msg.CreatedAt,
-						EditedAt:  msg.EditedAt,
-						MyMassage: isMyMessage,
-						SenderID:  &msg.CreatedByID,
-						RoomID:    &msg.ChatRoomID,
-						User:      *currentUser,
-					},
-				}
-				if isPrivate {
-					otherUser := newMessageOut.OtherUser
-
-					if isMyMessage {
-						model.Name = currentUser.Name
-						model.OtherUser = otherUser
-					} else {
-						model.Name = otherUser.Name
-						model.OtherUser = currentUser
-					}
-				}
-				return WSClientEventMessage{
-					Type:      WSNewRoomEvent,
-					DataModel: model,
-				}
-			})
+		broadcastNewMessage(newMessageOut, currentUser)
+	}
+
+	return nil
+}
+
+// newSentMessageResource builds the resource of the message as seen by the
+// viewer, the sender falls back to msg.CreatedBy if not given.
+func newSentMessageResource(msg *ChatMessage, sender *User, viewerID string) SentMessageResource {
+	out := SentMessageResource{
+		ID:        msg.ID,
+		Content:   msg.Content,
+		Type:      msg.Type,
+		SentAt:    msg.CreatedAt,
+		EditedAt:  msg.EditedAt,
+		MyMassage: msg.CreatedByID.String() == viewerID,
+		SenderID:  &msg.CreatedByID,
+		RoomID:    &msg.ChatRoomID,
+	}
+	if sender == nil {
+		sender = msg.CreatedBy
+	}
+	if sender != nil {
+		out.User = *sender
+	}
+	if msg.ReplyToID != nil {
+		out.ReplyToID = msg.ReplyToID
+		out.ThreadRootID = msg.ThreadRootID
+		if msg.ReplyTo != nil {
+			out.ReplyTo = newMessagePreviewResource(msg.ReplyTo)
+		}
+	}
+	if msg.ReplyCount > 0 {
+		out.ReplyCount = msg.ReplyCount
+		out.LastReplyAt = msg.LastReplyAt
+	}
+	if msg.Attachment != nil {
+		out.Attachment = newAttachmentResource(msg.Attachment)
+	}
+	if msg.Location != nil {
+		out.Location = newLocationResource(msg.Location)
+	}
+	if msg.Poll != nil {
+		out.Poll = newPollResource(msg.Poll, nil, viewerID)
+	}
+	out.PlayOnce = msg.PlayOnce
+	out.ExpiresAt = msg.ExpiresAt
+	out.SystemEvent = msg.SystemEvent
+	out.TargetMessageID = msg.TargetMessageID
+	if msg.IsForwarded() {
+		out.ForwardedFrom = &ForwardedFromResource{
+			MessageID: msg.ForwardedFromMessageID,
+			SenderID:  msg.ForwardedFromUserID,
+			RoomID:    msg.ForwardedFromRoomID,
 		}
+		if msg.ForwardedFromUser != nil {
+			out.ForwardedFrom.SenderName = msg.ForwardedFromUser.Name
+		}
+	}
+	return out
+}
 
-		go func() {
-			// delay the message broadcast if the room is new
-			if newMessageOut.NewRoomCreated {
-				<-time.After(500 * time.Millisecond)
-			}
+const messagePreviewLength = 100
 
-			BroadcastWSMassage(usersIds, func(userId string) WSClientEventMessage {
-				isMyMessage := userId == currentUserId
-				return WSClientEventMessage{
-					Type: WSMessageEvent,
-					DataModel: SentMessageResource{
-						ID:        msg.ID,
-						Content:   msg.Content,
-						Type:      msg.Type,
-						SentAt:    msg.CreatedAt,
-						EditedAt:  msg.EditedAt,
-						MyMassage: isMyMessage,
-						SenderID:  &msg.CreatedByID,
-						RoomID:    &msg.ChatRoomID,
-						User:      *currentUser,
-					},
-				}
-			})
-		}()
+func newMessagePreviewResource(msg *ChatMessage) *MessagePreviewResource {
+	content := []rune(msg.Content)
+	if len(content) > messagePreviewLength {
+		content = append(content[:messagePreviewLength], '…')
+	}
+	out := &MessagePreviewResource{
+		ID:       msg.ID,
+		Content:  string(content),
+		Type:     msg.Type,
+		SenderID: msg.CreatedByID,
+	}
+	if msg.CreatedBy != nil {
+		out.SenderName = msg.CreatedBy.Name
 	}
+	return out
+}
 
-	return nil
+// getRoomForMember returns the room only if the user is one of its members.
+func getRoomForMember(tx *gorm.DB, roomID string, u *User) (*ChatRoom, error) {
+	if _, err := UUIDFromString(roomID); err != nil {
+		return nil, fiber.NewError(fiber.StatusUnprocessableEntity, "invalid room_id")
+	}
+	room := &ChatRoom{}
+	if err := tx.Where("id = ?", roomID).
+		Where("? = ANY(users_ids)", u.ID).
+		First(room).Error; err != nil &&
+		!errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if room.ID.IsEmpty() {
+		return nil, fiber.NewError(fiber.StatusUnauthorized,
+			"room not found, or you are not a member of this room")
+	}
+	return room, nil
 }
 
 type newMessageOutput struct {
 	Room           ChatRoom
 	OtherUser      *User // only for private chat
 	Message        ChatMessage
+	Mentions       []ChatMessageMention
 	NewRoomCreated bool
 }
 
 func createNewMessage(in *SendMessageInput) (*newMessageOutput, error) {
+	return createNewMessageTx(DB(), in)
+}
+
+// createNewMessageTx is createNewMessage within tx, the message is created in a
+// nested transaction (a savepoint) when tx is one.
+func createNewMessageTx(tx *gorm.DB, in *SendMessageInput) (*newMessageOutput, error) {
 	if in.U.ID.String() == in.OtherUserID {
 		return nil, fiber.NewError(fiber.StatusBadRequest, "cannot send message to yourself")
 	}
@@ -474,8 +772,6 @@ func createNewMessage(in *SendMessageInput) (*newMessageOutput, error) {
 		return nil, fiber.NewError(fiber.StatusBadRequest, "room_id or other_user_id is required")
 	}
 
-	tx := DB()
-
 	currentUser := in.U
 	room := &ChatRoom{}
 	otherUser := &User{}
@@ -516,7 +812,67 @@ func createNewMessage(in *SendMessageInput) (*newMessageOutput, error) {
 		roomAlreadyExists = !room.ID.IsEmpty()
 	}
 
+	var replyTo *ChatMessage
+	if in.ReplyToID != nil {
+		if !roomAlreadyExists {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "replied message not found in this room")
+		}
+		replyTo = &ChatMessage{}
+		if err := tx.Joins("CreatedBy").
+			Where("chat_messages.id = ?", *in.ReplyToID).
+			Where("chat_messages.chat_room_id = ?", room.ID).
+			First(replyTo).Error; err != nil &&
+			!errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		if replyTo.ID == 0 {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "replied message not found in this room")
+		}
+	}
+
+	if in.AttachmentID != "" && in.Location != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "a message can not carry both an attachment and a location")
+	}
+	if in.Poll != nil && (in.AttachmentID != "" || in.Location != nil) {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "a poll can not carry an attachment or a location")
+	}
+
+	var attachment *Attachment
+	if in.ForwardOf != nil && in.ForwardOf.Attachment != nil {
+		// the attachment is shared with the original message, it's not copied
+		attachment = in.ForwardOf.Attachment
+	} else if in.AttachmentID != "" {
+		attachment = &Attachment{}
+		if err := tx.Where("id = ?", in.AttachmentID).
+			Where("uploaded_by_id = ?", currentUser.ID).
+			First(attachment).Error; err != nil &&
+			!errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		if attachment.ID.IsEmpty() {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "attachment not found, you can only send your own uploads")
+		}
+	} else if strings.TrimSpace(in.Content) == "" && in.Location == nil && in.Poll == nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "content, attachment_id, location or poll is required")
+	}
+	var poll *Poll
+	if in.Poll != nil {
+		var err error
+		if poll, err = newMessagePoll(0, in.Poll); err != nil {
+			return nil, err
+		}
+	}
+	if in.PlayOnce && (attachment == nil || attachment.MessageType() != CMTypeAudio) {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "only voice notes can be sent as play once")
+	}
+	if in.TTLSeconds != nil {
+		if err := validateMessageTTL(*in.TTLSeconds, true); err != nil {
+			return nil, err
+		}
+	}
+
 	var msg *ChatMessage
+	var mentions []ChatMessageMention
 
 	newChatRoomCreated := false
 
@@ -530,11 +886,62 @@ func createNewMessage(in *SendMessageInput) (*newMessageOutput, error) {
 			CreatedByID: currentUser.ID,
 			Content:     in.Content,
 			Type:        CMTypeText,
+			ReplyToID:   in.ReplyToID,
+			PlayOnce:    in.PlayOnce,
+		}
+		if replyTo != nil {
+			// a reply to a reply belongs to the thread of its root
+			msg.ThreadRootID = &replyTo.ID
+			if replyTo.ThreadRootID != nil {
+				msg.ThreadRootID = replyTo.ThreadRootID
+			}
+		}
+		if ttl := messageTTL(room, in.TTLSeconds); ttl > 0 {
+			expiresAt := time.Now().Add(ttl)
+			msg.ExpiresAt = &expiresAt
+		}
+		if src := in.ForwardOf; src != nil {
+			// forwarding a forwarded message keeps the original references
+			msg.ForwardedFromMessageID = &src.ID
+			msg.ForwardedFromUserID = &src.CreatedByID
+			msg.ForwardedFromRoomID = &src.ChatRoomID
+			if src.IsForwarded() {
+				msg.ForwardedFromMessageID = src.ForwardedFromMessageID
+				msg.ForwardedFromUserID = src.ForwardedFromUserID
+				msg.ForwardedFromRoomID = src.ForwardedFromRoomID
+			}
+			// a disappearing message does not outlive its source once forwarded
+			if src.ExpiresAt != nil && (msg.ExpiresAt == nil || src.ExpiresAt.Before(*msg.ExpiresAt)) {
+				expiresAt := *src.ExpiresAt
+				msg.ExpiresAt = &expiresAt
+			}
+		}
+		if attachment != nil {
+			msg.AttachmentID = &attachment.ID
+			msg.Type = attachment.MessageType()
+		}
+		if in.Location != nil {
+			msg.Type = CMTypeLocation
+		}
+		if poll != nil {
+			// the question is the content, for the previews and the search
+			msg.Type = CMTypePoll
+			msg.Content = poll.Question
 		}
 		if roomAlreadyExists {
 			if err := tx.Create(msg).Error; err != nil {
 				return err
 			}
+			if msg.ThreadRootID != nil {
+				if err := tx.Model(&ChatMessage{}).
+					Where("id = ?", *msg.ThreadRootID).
+					UpdateColumns(map[string]interface{}{
+						"reply_count":   gorm.Expr("reply_count + 1"),
+						"last_reply_at": msg.CreatedAt,
+					}).Error; err != nil {
+					return err
+				}
+			}
 			room.LatestMessageID = &msg.ID
 			if err := tx.Updates(room).Error; err != nil {
 				return err
@@ -561,15 +968,46 @@ func createNewMessage(in *SendMessageInput) (*newMessageOutput, error) {
 				return err
 			}
 		}
-		return nil
+		if in.Location != nil {
+			msg.Location = newMessageLocation(msg.ID, in.Location)
+			if err := tx.Create(msg.Location).Error; err != nil {
+				return err
+			}
+		}
+		if poll != nil {
+			poll.ChatMessageID = msg.ID
+			for i := range poll.Options {
+				poll.Options[i].ChatMessageID = msg.ID
+			}
+			if err := tx.Create(poll).Error; err != nil {
+				return err
+			}
+			msg.Poll = poll
+		}
+		if in.ForwardOf != nil {
+			// the mentions were meant for the members of the original room
+			return nil
+		}
+		var err error
+		mentions, err = saveMessageMentions(tx, msg, room)
+		return err
 	})
 	if txError != nil {
 		return nil, txError
 	}
+	msg.ReplyTo = replyTo
+	msg.Attachment = attachment
+	if src := in.ForwardOf; src != nil {
+		msg.ForwardedFromUser = src.CreatedBy
+		if src.IsForwarded() {
+			msg.ForwardedFromUser = src.ForwardedFromUser
+		}
+	}
 
 	return &newMessageOutput{
 		Room:           *room,
 		Message:        *msg,
+		Mentions:       mentions,
 		OtherUser:      otherUser,
 		NewRoomCreated: newChatRoomCreated,
 	}, nil
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	minMessageTTL            = 5                  // seconds
+	maxMessageTTL            = 365 * 24 * 60 * 60 // seconds
+	expiredMessagesInterval  = 10 * time.Second
+	expiredMessagesBatchSize = 200
+)
+
+type RoomSettingsResource struct {
+	RoomID      UUID `json:"room_id"`
+	MessageTTL  *int `json:"message_ttl"` // seconds, null when the messages don't disappear
+	UpdatedByID UUID `json:"updated_by_id"`
+}
+
+// validateMessageTTL checks a time to live in seconds, 0 is only meaningful
+// for a message sent in a room with disappearing messages.
+func validateMessageTTL(seconds int, allowZero bool) error {
+	if seconds == 0 && allowZero {
+		return nil
+	}
+	if seconds < minMessageTTL || seconds > maxMessageTTL {
+		return fiber.NewError(fiber.StatusUnprocessableEntity, "ttl must be between 5 seconds and 365 days")
+	}
+	return nil
+}
+
+// messageTTL returns how long a new message of the room lives, the override
+// of the message wins over the setting of the room. 0 means for ever.
+func messageTTL(room *ChatRoom, override *int) time.Duration {
+	if override != nil {
+		return time.Duration(*override) * time.Second
+	}
+	if room != nil && room.MessageTTL != nil {
+		return time.Duration(*room.MessageTTL) * time.Second
+	}
+	return 0
+}
+
+type SetRoomMessageTTLInput struct {
+	U          *User
+	RoomID     string
+	TTLSeconds *int // nil turns the disappearing messages off
+}
+
+// SetRoomMessageTTL changes the time to live of the messages sent to the room
+// from now on, the messages already sent keep theirs. Any member can change it
+// in a private chat, only the admins in a group.
+func SetRoomMessageTTL(in *SetRoomMessageTTLInput) (*RoomSettingsResource, error) {
+	tx := DB()
+	room, err := getRoomForMember(tx, in.RoomID, in.U)
+	if err != nil {
+		return nil, err
+	}
+	if !room.IsPrivate() && !room.IsAdmin(in.U.ID.String()) {
+		return nil, fiber.NewError(fiber.StatusForbidden, "only the admins can change the settings of the room")
+	}
+	if in.TTLSeconds != nil {
+		if err := validateMessageTTL(*in.TTLSeconds, false); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Model(room).
+		Updates(map[string]interface{}{
+			"message_ttl": in.TTLSeconds,
+			"updated_at":  time.Now(),
+		}).Error; err != nil {
+		return nil, err
+	}
+
+	out := &RoomSettingsResource{
+		RoomID:      room.ID,
+		MessageTTL:  in.TTLSeconds,
+		UpdatedByID: in.U.ID,
+	}
+	BroadcastWSMassage(room.UsersIDs, func(userId string) WSClientEventMessage {
+		return WSClientEventMessage{
+			Type:      WSRoomUpdatedEvent,
+			DataModel: *out,
+		}
+	})
+	return out, nil
+}
+
+// expiredMessagesLoop sweeps the expired messages until stop is closed, a
+// batch is never cut between its commit and the deletion of its files.
+func expiredMessagesLoop(stop <-chan struct{}) {
+	ticker := time.NewTicker(expiredMessagesInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		for {
+			swept, err := sweepExpiredMessages()
+			if err != nil {
+				AppLogger.WithError(err).Error("failed to sweep expired messages")
+				break
+			}
+			// a full batch means that more messages may have expired
+			if swept < expiredMessagesBatchSize || isStopped(stop) {
+				break
+			}
+		}
+	}
+}
+
+type expiredRoomMessages struct {
+	room          ChatRoom
+	messagesIDs   []uint
+	latestMessage *ChatMessage // set when the latest message of the room expired
+}
+
+// sweepExpiredMessages hard deletes a batch of the expired messages, along
+// with their reactions, mentions, edits and the attachments no other message
+// uses. The rows are locked with SKIP LOCKED so that several server instances
+// can sweep at once.
+func sweepExpiredMessages() (int, error) {
+	expired := []ChatMessage{}
+	expiredRooms := map[uint]UUID{}
+	rooms := map[UUID]*expiredRoomMessages{}
+	var orphanAttachments []Attachment
+	err := DB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().
+			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Select("id", "chat_room_id", "thread_root_id", "attachment_id", "deleted_at").
+			Where("expires_at <= ?", time.Now()).
+			Order("expires_at ASC").
+			Limit(expiredMessagesBatchSize).
+			Find(&expired).Error; err != nil {
+			return err
+		}
+		if len(expired) == 0 {
+			return nil
+		}
+
+		messagesIDs := []uint{}
+		attachmentsIDs := []UUID{}
+		for _, v := range expired {
+			messagesIDs = append(messagesIDs, v.ID)
+			expiredRooms[v.ID] = v.ChatRoomID
+			if v.AttachmentID != nil {
+				attachmentsIDs = append(attachmentsIDs, *v.AttachmentID)
+			}
+		}
+		// the foreign keys cascade to the rows of the messages, and set
+		// latest_message_id to null in their rooms
+		if err := tx.Unscoped().
+			Where("id IN ?", messagesIDs).
+			Delete(&ChatMessage{}).Error; err != nil {
+			return err
+		}
+
+		threadsRoots := map[uint]bool{}
+		for _, v := range expired {
+			// the soft deleted replies were already uncounted
+			if v.ThreadRootID != nil && !v.DeletedAt.Valid && !threadsRoots[*v.ThreadRootID] {
+				threadsRoots[*v.ThreadRootID] = true
+				if err := recountThreadReplies(tx, *v.ThreadRootID); err != nil {
+					return err
+				}
+			}
+			if v.DeletedAt.Valid {
+				// the members were told when it was deleted
+				continue
+			}
+			if rooms[v.ChatRoomID] == nil {
+				rooms[v.ChatRoomID] = &expiredRoomMessages{}
+			}
+			rooms[v.ChatRoomID].messagesIDs = append(rooms[v.ChatRoomID].messagesIDs, v.ID)
+		}
+
+		for roomID, v := range rooms {
+			if err := tx.Unscoped().Where("id = ?", roomID).First(&v.room).Error; err != nil {
+				return err
+			}
+			if v.room.LatestMessageID != nil {
+				continue
+			}
+			var err error
+			if v.latestMessage, err = repointLatestMessage(tx, roomID); err != nil {
+				return err
+			}
+		}
+
+		if len(attachmentsIDs) == 0 {
+			return nil
+		}
+		if err := tx.Preload("Thumbnails").
+			Where("id IN ?", attachmentsIDs).
+			Where(`NOT EXISTS (SELECT 1 FROM "chat_messages" WHERE "chat_messages"."attachment_id" = "attachments"."id")`).
+			Where(`NOT EXISTS (SELECT 1 FROM "scheduled_messages" WHERE "scheduled_messages"."attachment_id" = "attachments"."id")`).
+			Find(&orphanAttachments).Error; err != nil {
+			return err
+		}
+		for _, a := range orphanAttachments {
+			if err := tx.Unscoped().Delete(&Attachment{}, "id = ?", a.ID).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// the files are removed once the rows are gone for good, a failure only
+	// leaves an unreachable file behind
+	for _, a := range orphanAttachments {
+		keys := []string{a.StorageKey}
+		for _, t := range a.Thumbnails {
+			keys = append(keys, t.StorageKey)
+		}
+		for _, key := range keys {
+			if err := Storage().Delete(context.Background(), key); err != nil && !errors.Is(err, ErrObjectNotFound) {
+				AppLogger.WithError(err).WithField("key", key).Error("failed to delete the file of an expired message")
+			}
+		}
+	}
+
+	// the replay logs must not hand the content out once it is gone
+	wsEventsLog.Redact(expiredRooms)
+
+	for _, v := range rooms {
+		for _, messageID := range v.messagesIDs {
+			// the latest message is only sent along with the last deletion
+			var latestMessage *ChatMessage
+			if messageID == v.messagesIDs[len(v.messagesIDs)-1] {
+				latestMessage = v.latestMessage
+			}
+			broadcastMessageDeleted(&v.room, messageID, latestMessage)
+		}
+	}
+	return len(expired), nil
+}
+
+func (r SentMessageResource) redactMessages(messagesRooms map[uint]UUID) (interface{}, bool) {
+	if roomID, ok := messagesRooms[r.ID]; ok {
+		return MessageDeletedResource{MessageID: r.ID, RoomID: roomID}, true
+	}
+	if r.ReplyTo != nil {
+		if _, ok := messagesRooms[r.ReplyTo.ID]; ok {
+			r.ReplyTo = nil
+			return r, true
+		}
+	}
+	return r, false
+}
+
+func (r MentionEventResource) redactMessages(messagesRooms map[uint]UUID) (interface{}, bool) {
+	message, ok := r.Message.redactMessages(messagesRooms)
+	if !ok {
+		return r, false
+	}
+	if deleted, ok := message.(MessageDeletedResource); ok {
+		return deleted, true
+	}
+	r.Message = message.(SentMessageResource)
+	return r, true
+}
+
+func (r PollUpdatedResource) redactMessages(messagesRooms map[uint]UUID) (interface{}, bool) {
+	if _, ok := messagesRooms[r.MessageID]; ok {
+		return MessageDeletedResource{MessageID: r.MessageID, RoomID: r.RoomID}, true
+	}
+	return r, false
+}
+
+func (r MessageDeletedResource) redactMessages(messagesRooms map[uint]UUID) (interface{}, bool) {
+	lastMessage, ok := redactLastMessage(r.LastMessage, messagesRooms)
+	r.LastMessage = lastMessage
+	return r, ok
+}
+
+func (r ChatRoomResource) redactMessages(messagesRooms map[uint]UUID) (interface{}, bool) {
+	lastMessage, ok := redactLastMessage(r.LastMessage, messagesRooms)
+	r.LastMessage = lastMessage
+	return r, ok
+}
+
+// redactLastMessage returns the latest message of a room without the content
+// of the messages, nil if it is one of them.
+func redactLastMessage(r *SentMessageResource, messagesRooms map[uint]UUID) (*SentMessageResource, bool) {
+	if r == nil {
+		return nil, false
+	}
+	message, ok := r.redactMessages(messagesRooms)
+	if !ok {
+		return r, false
+	}
+	if redacted, ok := message.(SentMessageResource); ok {
+		return &redacted, true
+	}
+	return nil, true
+}
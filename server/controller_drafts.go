@@ -0,0 +1,180 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+const draftThrottle = 2 * time.Second // minimum interval between two drafts saved by the same connection in a room
+
+type DraftResource struct {
+	RoomID    UUID      `json:"room_id"`
+	Content   string    `json:"content"` // empty once the draft is cleared
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type SaveDraftInput struct {
+	U        *User
+	RoomID   string
+	Content  string // an empty content clears the draft
+	ClientID uint64 // the connection the draft was saved from, 0 if not saved over the websocket
+}
+
+// SaveDraft replaces the draft of the user in the room, and sends it to the
+// other connections of the user. The clients clear the draft once the message
+// is sent, the last saved draft wins when several devices write at once.
+func SaveDraft(in *SaveDraftInput) (*DraftResource, error) {
+	tx := DB()
+	room, err := getRoomForMember(tx, in.RoomID, in.U)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &DraftResource{
+		RoomID:    room.ID,
+		UpdatedAt: time.Now(),
+	}
+	if strings.TrimSpace(in.Content) == "" {
+		if err := tx.Where("chat_room_id = ?", room.ID).
+			Where("user_id = ?", in.U.ID).
+			Delete(&ChatRoomDraft{}).Error; err != nil {
+			return nil, err
+		}
+	} else {
+		out.Content = in.Content
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "chat_room_id"}, {Name: "user_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"content", "updated_at"}),
+		}).Create(&ChatRoomDraft{
+			ChatRoomID: room.ID,
+			UserID:     in.U.ID,
+			Content:    out.Content,
+			UpdatedAt:  out.UpdatedAt,
+		}).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	SendMessageToOtherWSClients(in.U.ID.String(), in.ClientID, WSClientEventMessage{
+		Type:      WSDraftUpdatedEvent,
+		DataModel: *out,
+	})
+	return out, nil
+}
+
+// loadRoomsDrafts returns the drafts of the user in the rooms indexed by room
+// id, the rooms without a draft are missing.
+func loadRoomsDrafts(u *User, roomsIDs []UUID) (map[string]ChatRoomDraft, error) {
+	out := map[string]ChatRoomDraft{}
+	if len(roomsIDs) == 0 {
+		return out, nil
+	}
+	drafts := []ChatRoomDraft{}
+	if err := DB().Where("chat_room_id IN ?", roomsIDs).
+		Where("user_id = ?", u.ID).
+		Find(&drafts).Error; err != nil {
+		return nil, err
+	}
+	for _, v := range drafts {
+		out[v.ChatRoomID.String()] = v
+	}
+	return out, nil
+}
+
+type SSEDraft struct {
+	ChatRoomID string `json:"room_id"`
+	Content    string `json:"content"`
+}
+
+// wsDraftState is the draft throttling state of a connection in a room.
+type wsDraftState struct {
+	savedAt time.Time
+	pending *SSEDraft   // the latest draft received while throttled
+	timer   *time.Timer // saves the pending draft once the throttle interval ends
+
+	// held across SaveDraft, taken before draftsMutex is released so that
+	// the drafts are written in the order they were decided
+	saveMutex sync.Mutex
+}
+
+// handleDraftEvent saves the drafts sent over the websocket at most once per
+// draftThrottle for every room. Unlike typing events the drafts received in
+// between are not dropped, the latest one is saved when the interval ends.
+// Clearing the draft is never delayed, a delayed save would bring it back.
+func handleDraftEvent(clientConn *WSClientSocket, data SSEDraft) error {
+	clientConn.draftsMutex.Lock()
+	state, exists := clientConn.drafts[data.ChatRoomID]
+	clientConn.draftsMutex.Unlock()
+	if !exists {
+		// the state is only kept for the rooms of the user, the map would
+		// grow with every id the client makes up otherwise. The read pump is
+		// the only one adding states, no other can be added meanwhile.
+		if _, err := getRoomForMember(DB(), data.ChatRoomID, clientConn.user); err != nil {
+			return err
+		}
+		state = &wsDraftState{}
+		clientConn.draftsMutex.Lock()
+		clientConn.drafts[data.ChatRoomID] = state
+		clientConn.draftsMutex.Unlock()
+	}
+
+	clientConn.draftsMutex.Lock()
+	cleared := strings.TrimSpace(data.Content) == ""
+	if !cleared && time.Since(state.savedAt) < draftThrottle {
+		state.pending = &data
+		if state.timer == nil {
+			state.timer = time.AfterFunc(draftThrottle-time.Since(state.savedAt), func() {
+				flushPendingDraft(clientConn, data.ChatRoomID, state)
+			})
+		}
+		clientConn.draftsMutex.Unlock()
+		return nil
+	}
+	if state.timer != nil {
+		state.timer.Stop()
+		state.timer = nil
+	}
+	state.pending = nil
+	state.savedAt = time.Now()
+	// waits for a flush already saving, a clear must land after it
+	state.saveMutex.Lock()
+	defer state.saveMutex.Unlock()
+	clientConn.draftsMutex.Unlock()
+
+	_, err := SaveDraft(&SaveDraftInput{
+		U:        clientConn.user,
+		RoomID:   data.ChatRoomID,
+		Content:  data.Content,
+		ClientID: clientConn.id,
+	})
+	return err
+}
+
+// flushPendingDraft saves the latest draft received while throttled, it runs
+// even if the connection was closed meanwhile.
+func flushPendingDraft(clientConn *WSClientSocket, roomID string, state *wsDraftState) {
+	clientConn.draftsMutex.Lock()
+	pending := state.pending
+	state.pending = nil
+	state.timer = nil
+	if pending == nil {
+		clientConn.draftsMutex.Unlock()
+		return
+	}
+	state.savedAt = time.Now()
+	state.saveMutex.Lock()
+	defer state.saveMutex.Unlock()
+	clientConn.draftsMutex.Unlock()
+
+	if _, err := SaveDraft(&SaveDraftInput{
+		U:        clientConn.user,
+		RoomID:   roomID,
+		Content:  pending.Content,
+		ClientID: clientConn.id,
+	}); err != nil {
+		AppLogger.WithError(err).Warn("failed to save a throttled draft")
+	}
+}
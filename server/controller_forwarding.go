@@ -0,0 +1,111 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	maxForwardedMessages = 20 // per request
+	maxForwardTargets    = 5  // rooms per request
+)
+
+type ForwardMessagesInput struct {
+	U           *User
+	MessagesIDs []uint
+	RoomsIDs    []string
+}
+
+// ForwardMessages sends a copy of the messages to each of the rooms, in the
+// order they were first sent. The user must still be a member of the rooms of
+// the messages and of the target rooms. The attachments are shared with the
+// original messages, and the copies keep a reference to where they come from.
+// The copies of disappearing messages expire along with them. Either all the
+// messages are forwarded or none.
+func ForwardMessages(in *ForwardMessagesInput) ([]SentMessageResource, error) {
+	if len(in.MessagesIDs) == 0 || len(in.RoomsIDs) == 0 {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "message_ids and room_ids are required")
+	}
+	if len(in.MessagesIDs) > maxForwardedMessages {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "too many messages to forward at once")
+	}
+	if len(in.RoomsIDs) > maxForwardTargets {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "too many rooms to forward to at once")
+	}
+
+	tx := DB()
+	sources := []*ChatMessage{}
+	for _, id := range in.MessagesIDs {
+		msg, _, err := getMessageForMember(tx, id, in.U)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case msg.Type == CMTypeSystem:
+			return nil, fiber.NewError(fiber.StatusBadRequest, "system messages can not be forwarded")
+		case msg.Type == CMTypePoll:
+			return nil, fiber.NewError(fiber.StatusBadRequest, "polls can not be forwarded")
+		case msg.PlayOnce:
+			return nil, fiber.NewError(fiber.StatusBadRequest, "play once voice notes can not be forwarded")
+		case msg.ExpiresAt != nil && !msg.ExpiresAt.After(time.Now()):
+			// not swept yet
+			return nil, fiber.NewError(fiber.StatusNotFound, "message not found")
+		}
+		sources = append(sources, msg)
+	}
+	sort.SliceStable(sources, func(i, j int) bool {
+		if sources[i].CreatedAt.Equal(sources[j].CreatedAt) {
+			return sources[i].ID < sources[j].ID
+		}
+		return sources[i].CreatedAt.Before(sources[j].CreatedAt)
+	})
+	for _, roomID := range in.RoomsIDs {
+		if _, err := getRoomForMember(tx, roomID, in.U); err != nil {
+			return nil, err
+		}
+	}
+
+	outputs := []*newMessageOutput{}
+	if err := tx.Transaction(func(tx *gorm.DB) error {
+		for _, roomID := range in.RoomsIDs {
+			for _, src := range sources {
+				sendIn := &SendMessageInput{
+					U:         in.U,
+					RoomID:    roomID,
+					Content:   src.Content,
+					ForwardOf: src,
+				}
+				if loc := src.Location; loc != nil {
+					sendIn.Location = &LocationPayload{
+						Latitude:  &loc.Latitude,
+						Longitude: &loc.Longitude,
+						Accuracy:  loc.Accuracy,
+					}
+					if loc.PlaceName != nil {
+						sendIn.Location.PlaceName = *loc.PlaceName
+					}
+				}
+				out, err := createNewMessageTx(tx, sendIn)
+				if err != nil {
+					return err
+				}
+				outputs = append(outputs, out)
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	// broadcast once committed, the members must not see a message that
+	// could still be rolled back
+	messages := []SentMessageResource{}
+	for _, out := range outputs {
+		broadcastNewMessage(out, in.U)
+		messages = append(messages, newSentMessageResource(&out.Message, in.U, in.U.ID.String()))
+	}
+	return messages, nil
+}
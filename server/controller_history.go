@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	historyWindowDefaultLimit = 50
+	historyWindowMaxLimit     = 100
+)
+
+type HistoryAnchorMode string
+
+const (
+	HistoryAround HistoryAnchorMode = "around" // the anchor with the messages on both sides of it
+	HistoryBefore HistoryAnchorMode = "before" // the messages older than the anchor
+	HistoryAfter  HistoryAnchorMode = "after"  // the messages newer than the anchor
+)
+
+type RoomMessagesWindowInput struct {
+	U        *User
+	RoomID   string
+	Mode     HistoryAnchorMode
+	AnchorID uint
+	Limit    int
+}
+
+type MessagesWindowResource struct {
+	Data     []SentMessageResource `json:"data"` // newest first, like the pages of the room messages
+	AnchorID uint                  `json:"anchor_id"`
+	HasOlder bool                  `json:"has_older"` // more messages can be fetched with before=<oldest id>
+	HasNewer bool                  `json:"has_newer"` // more messages can be fetched with after=<newest id>
+}
+
+// GetRoomMessagesWindow returns a window of the room history next to a
+// message, it's how the clients open a conversation at a search result or a
+// mention and then scroll from there in both directions.
+func GetRoomMessagesWindow(in *RoomMessagesWindowInput) (*MessagesWindowResource, error) {
+	tx := DB()
+	room, err := getRoomForMember(tx, in.RoomID, in.U)
+	if err != nil {
+		return nil, err
+	}
+
+	// the anchor may have been deleted since the link to it was shared, it
+	// still marks a position in the history
+	anchor := &ChatMessage{}
+	if err := tx.Unscoped().
+		Where("id = ?", in.AnchorID).
+		Where("chat_room_id = ?", room.ID).
+		First(anchor).Error; err != nil &&
+		!errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if anchor.ID == 0 {
+		return nil, fiber.NewError(fiber.StatusNotFound, "message not found in this room")
+	}
+
+	limit := in.Limit
+	if limit <= 0 {
+		limit = historyWindowDefaultLimit
+	}
+	limit = min(limit, historyWindowMaxLimit)
+
+	out := &MessagesWindowResource{AnchorID: anchor.ID}
+	var older, newer []ChatMessage
+	switch in.Mode {
+	case HistoryBefore:
+		older, out.HasOlder, err = roomMessagesNextTo(anchor, true, limit)
+		out.HasNewer = true // the anchor at least
+	case HistoryAfter:
+		newer, out.HasNewer, err = roomMessagesNextTo(anchor, false, limit)
+		out.HasOlder = true
+	default:
+		// the anchor takes one slot, the older messages get the extra one
+		newerLimit := (limit - 1) / 2
+		older, out.HasOlder, err = roomMessagesNextTo(anchor, true, limit-1-newerLimit)
+		if err == nil && newerLimit > 0 {
+			newer, out.HasNewer, err = roomMessagesNextTo(anchor, false, newerLimit)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	messages := newer
+	if in.Mode == HistoryAround && !anchor.DeletedAt.Valid {
+		if err := preloadMessageContent(tx.Joins("CreatedBy")).
+			Preload("ReplyTo.CreatedBy").
+			Where("chat_messages.id = ?", anchor.ID).
+			First(anchor).Error; err != nil {
+			return nil, err
+		}
+		messages = append(messages, *anchor)
+	}
+	messages = append(messages, older...)
+
+	page, err := transformMessagesPage(&PaginatedData[ChatMessage]{Data: messages}, in.U)
+	if err != nil {
+		return nil, err
+	}
+	out.Data = page.Data
+	return out, nil
+}
+
+// roomMessagesNextTo returns up to limit messages of the anchor room that are
+// older, or newer, than the anchor, newest first. The messages are ordered by
+// (created_at, id) so that messages created in the same second keep a stable
+// order. It also reports whether more messages exist past the limit.
+func roomMessagesNextTo(anchor *ChatMessage, older bool, limit int) ([]ChatMessage, bool, error) {
+	cmp, order := "<", "DESC"
+	if !older {
+		cmp, order = ">", "ASC"
+	}
+	out := []ChatMessage{}
+	if err := preloadMessageContent(DB().Joins("CreatedBy")).
+		Preload("ReplyTo.CreatedBy").
+		Where("chat_messages.chat_room_id = ?", anchor.ChatRoomID).
+		Where("(chat_messages.created_at, chat_messages.id) "+cmp+" (?, ?)", anchor.CreatedAt, anchor.ID).
+		Order("chat_messages.created_at " + order + ", chat_messages.id " + order).
+		Limit(limit + 1).
+		Find(&out).Error; err != nil {
+		return nil, false, err
+	}
+	hasMore := len(out) > limit
+	if hasMore {
+		out = out[:limit]
+	}
+	if !older {
+		slices.Reverse(out)
+	}
+	return out, hasMore, nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	imageProcessingWorkers   = 2
+	imageProcessingQueueSize = 256
+)
+
+var imageProcessingQueue = make(chan UUID, imageProcessingQueueSize)
+
+// StartImageProcessing starts the workers processing the image attachments,
+// and queues the images left unprocessed by a previous run.
+func StartImageProcessing() {
+	for i := 0; i < imageProcessingWorkers; i++ {
+		go imageProcessingLoop()
+	}
+	go func() {
+		pending := []Attachment{}
+		if err := DB().Select("id", "mime_type").
+			Where("processed_at IS NULL").
+			Where("mime_type LIKE ?", "image/%").
+			Find(&pending).Error; err != nil {
+			AppLogger.WithError(err).Error("failed to load the unprocessed images")
+			return
+		}
+		for _, v := range pending {
+			if isProcessableImage(v.MimeType) {
+				enqueueImageProcessing(v.ID)
+			}
+		}
+	}()
+}
+
+// enqueueImageProcessing queues the image, waiting for room in the queue when
+// the workers fall behind so the uploads are slowed down instead of piling up.
+func enqueueImageProcessing(attachmentID UUID) {
+	imageProcessingQueue <- attachmentID
+}
+
+func imageProcessingLoop() {
+	for attachmentID := range imageProcessingQueue {
+		if err := processAttachmentImage(attachmentID); err != nil {
+			AppLogger.WithError(err).
+				WithField("attachment_id", attachmentID.String()).
+				Error("failed to process image attachment")
+		}
+	}
+}
+
+// processAttachmentImage replaces the stored image with a version without
+// metadata, stores its thumbnails and records its dimensions and blurhash.
+// The images that can not be decoded, stripped or are too large are marked as
+// failed, they keep their metadata so only their uploader can download them.
+func processAttachmentImage(attachmentID UUID) error {
+	attachment := &Attachment{}
+	if err := DB().Where("id = ?", attachmentID).
+		First(attachment).Error; err != nil {
+		return err
+	}
+	if !attachment.NeedsImageProcessing() {
+		return nil
+	}
+
+	// a storage error leaves the image unprocessed, it is retried on restart
+	data, err := readStoredImage(attachment)
+	if err != nil {
+		return err
+	}
+	result, processErr := processImage(data, attachment.MimeType)
+	if processErr != nil {
+		n := time.Now()
+		if err := DB().Model(&Attachment{}).
+			Where("id = ?", attachment.ID).
+			UpdateColumns(map[string]interface{}{
+				"processed_at":         n,
+				"processing_failed_at": n,
+			}).Error; err != nil {
+			return err
+		}
+		attachment.ProcessedAt = &n
+		attachment.ProcessingFailedAt = &n
+		broadcastAttachmentUpdated(attachment)
+		return processErr
+	}
+
+	// the stripped image is stored next to the original, the row keeps
+	// pointing to the original until it is switched along with its size and
+	// checksum, so a failed update leaves both consistent
+	originalKey := attachment.StorageKey
+	if result.data != nil {
+		// a key of its own, a concurrent attempt can not overwrite or delete it
+		strippedKey := "attachments/" + NewUUIDv4().String()
+		if err := Storage().Put(ctx(), strippedKey, bytes.NewReader(result.data), int64(len(result.data)), attachment.MimeType); err != nil {
+			return err
+		}
+		sum := sha256.Sum256(result.data)
+		attachment.StorageKey = strippedKey
+		attachment.Size = int64(len(result.data))
+		attachment.Checksum = hex.EncodeToString(sum[:])
+	}
+
+	thumbnails := []AttachmentThumbnail{}
+	for _, v := range result.thumbnails {
+		key := "thumbnails/" + attachment.ID.String() + "/" + v.label
+		if err := Storage().Put(ctx(), key, bytes.NewReader(v.data), int64(len(v.data)), v.mimeType); err != nil {
+			return err
+		}
+		thumbnails = append(thumbnails, AttachmentThumbnail{
+			AttachmentID: attachment.ID,
+			Label:        v.label,
+			StorageKey:   key,
+			MimeType:     v.mimeType,
+			Width:        v.width,
+			Height:       v.height,
+			Size:         int64(len(v.data)),
+			CreatedAt:    time.Now(),
+		})
+	}
+
+	n := time.Now()
+	attachment.Width = &result.width
+	attachment.Height = &result.height
+	attachment.Blurhash = &result.blurhash
+	attachment.ProcessedAt = &n
+	attachment.Thumbnails = thumbnails
+	if err := DB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("attachment_id = ?", attachment.ID).
+			Delete(&AttachmentThumbnail{}).Error; err != nil {
+			return err
+		}
+		if len(thumbnails) > 0 {
+			if err := tx.Create(&thumbnails).Error; err != nil {
+				return err
+			}
+		}
+		rs := tx.Model(&Attachment{}).
+			Where("id = ?", attachment.ID).
+			Where("processed_at IS NULL").
+			UpdateColumns(map[string]interface{}{
+				"storage_key":  attachment.StorageKey,
+				"size":         attachment.Size,
+				"checksum":     attachment.Checksum,
+				"width":        result.width,
+				"height":       result.height,
+				"blurhash":     result.blurhash,
+				"processed_at": n,
+			})
+		if rs.Error != nil {
+			return rs.Error
+		}
+		if rs.RowsAffected == 0 {
+			return errors.New("image was processed concurrently")
+		}
+		return nil
+	}); err != nil {
+		if attachment.StorageKey != originalKey {
+			Storage().Delete(ctx(), attachment.StorageKey)
+		}
+		return err
+	}
+	if attachment.StorageKey != originalKey {
+		if err := Storage().Delete(ctx(), originalKey); err != nil && !errors.Is(err, ErrObjectNotFound) {
+			AppLogger.WithError(err).WithField("key", originalKey).Error("failed to delete the original of a processed image")
+		}
+	}
+
+	broadcastAttachmentUpdated(attachment)
+	return nil
+}
+
+func readStoredImage(attachment *Attachment) ([]byte, error) {
+	reader, err := Storage().Get(ctx(), attachment.StorageKey)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(io.LimitReader(reader, attachment.Size+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != attachment.Size {
+		return nil, errors.New("stored image size does not match the attachment size")
+	}
+	return data, nil
+}
+
+// broadcastAttachmentUpdated notifies the members of the rooms where the
+// attachment was sent with the updated messages.
+func broadcastAttachmentUpdated(attachment *Attachment) {
+	messages := []ChatMessage{}
+	if err := preloadMessageContent(DB().Joins("CreatedBy")).
+		Preload("ReplyTo.CreatedBy").
+		Where("chat_messages.attachment_id = ?", attachment.ID).
+		Where("chat_messages.deleted_at IS NULL").
+		Find(&messages).Error; err != nil {
+		AppLogger.WithError(err).Error("failed to load the messages of an updated attachment")
+		return
+	}
+	if len(messages) == 0 {
+		return
+	}
+	messagesIds := []uint{}
+	for _, v := range messages {
+		messagesIds = append(messagesIds, v.ID)
+	}
+	reactions, err := loadMessagesReactions(messagesIds)
+	if err != nil {
+		AppLogger.WithError(err).Error("failed to load the reactions of an updated attachment")
+		return
+	}
+	mentions, err := loadMessagesMentions(messagesIds)
+	if err != nil {
+		AppLogger.WithError(err).Error("failed to load the mentions of an updated attachment")
+		return
+	}
+
+	for i := range messages {
+		msg := &messages[i]
+		msg.Attachment = attachment
+		room := &ChatRoom{}
+		if err := DB().Where("id = ?", msg.ChatRoomID).
+			First(room).Error; err != nil {
+			continue
+		}
+		BroadcastWSMassage(room.UsersIDs, func(userId string) WSClientEventMessage {
+			resource := newSentMessageResource(msg, nil, userId)
+			resource.Reactions = summarizeReactions(reactions[msg.ID], userId)
+			resource.Mentions = summarizeMentions(mentions[msg.ID])
+			return WSClientEventMessage{
+				Type:      WSMessageUpdatedEvent,
+				DataModel: resource,
+			}
+		})
+	}
+}
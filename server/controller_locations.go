@@ -0,0 +1,267 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	liveLocationMinDuration = time.Minute
+	liveLocationMaxDuration = 8 * time.Hour
+	liveLocationThrottle    = time.Second // minimum interval between two broadcast updates of the same share
+)
+
+var (
+	liveLocationTracker = NewLiveLocationTracker()
+)
+
+// LocationPayload is the location sent by the clients, both for location
+// messages and live location updates.
+type LocationPayload struct {
+	Latitude  *float64 `json:"latitude" validate:"required,gte=-90,lte=90"`
+	Longitude *float64 `json:"longitude" validate:"required,gte=-180,lte=180"`
+	Accuracy  *float64 `json:"accuracy" validate:"omitempty,gte=0"` // in meters
+	PlaceName string   `json:"place_name" validate:"omitempty,max=255"`
+}
+
+type LocationResource struct {
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Accuracy  *float64 `json:"accuracy,omitempty"`
+	PlaceName *string  `json:"place_name,omitempty"`
+}
+
+func newLocationResource(l *ChatMessageLocation) *LocationResource {
+	return &LocationResource{
+		Latitude:  l.Latitude,
+		Longitude: l.Longitude,
+		Accuracy:  l.Accuracy,
+		PlaceName: l.PlaceName,
+	}
+}
+
+// newMessageLocation builds the location row of a message from a validated payload.
+func newMessageLocation(messageID uint, p *LocationPayload) *ChatMessageLocation {
+	out := &ChatMessageLocation{
+		ChatMessageID: messageID,
+		Latitude:      *p.Latitude,
+		Longitude:     *p.Longitude,
+		Accuracy:      p.Accuracy,
+	}
+	if placeName := strings.TrimSpace(p.PlaceName); placeName != "" {
+		out.PlaceName = &placeName
+	}
+	return out
+}
+
+type LiveLocationState string
+
+const (
+	LiveLocationStarted LiveLocationState = "started"
+	LiveLocationUpdated LiveLocationState = "updated"
+	LiveLocationStopped LiveLocationState = "stopped"
+	LiveLocationExpired LiveLocationState = "expired"
+)
+
+type LiveLocationResource struct {
+	RoomID    UUID              `json:"room_id"`
+	UserID    UUID              `json:"user_id"`
+	UserName  string            `json:"user_name"`
+	State     LiveLocationState `json:"state"`
+	Location  LocationResource  `json:"location"` // the latest known location
+	StartedAt time.Time         `json:"started_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+type liveLocationShare struct {
+	room      ChatRoom
+	user      *User
+	location  LocationResource
+	startedAt time.Time
+	updatedAt time.Time
+	expiresAt time.Time
+	timer     *time.Timer
+}
+
+func (s *liveLocationShare) resource(state LiveLocationState) LiveLocationResource {
+	return LiveLocationResource{
+		RoomID:    s.room.ID,
+		UserID:    s.user.ID,
+		UserName:  s.user.Name,
+		State:     state,
+		Location:  s.location,
+		StartedAt: s.startedAt,
+		UpdatedAt: s.updatedAt,
+		ExpiresAt: s.expiresAt,
+	}
+}
+
+// LiveLocationTracker keeps the in-memory live location shares of every user
+// per room, nothing here is persisted. The positions are only relayed to the
+// room members as ephemeral events.
+type LiveLocationTracker struct {
+	mu     sync.Mutex
+	shares map[string]*liveLocationShare
+}
+
+func NewLiveLocationTracker() *LiveLocationTracker {
+	return &LiveLocationTracker{shares: make(map[string]*liveLocationShare)}
+}
+
+func liveLocationKey(roomID string, userID UUID) string { return roomID + ":" + userID.String() }
+
+// Start starts sharing the location of the user in the room until duration
+// elapses, an already running share of the user in the room is replaced.
+func (t *LiveLocationTracker) Start(room ChatRoom, u *User, duration time.Duration, location LocationResource) {
+	key := liveLocationKey(room.ID.String(), u.ID)
+	n := time.Now()
+	share := &liveLocationShare{
+		room:      room,
+		user:      u,
+		location:  location,
+		startedAt: n,
+		updatedAt: n,
+		expiresAt: n.Add(duration),
+	}
+
+	t.mu.Lock()
+	if previous, exists := t.shares[key]; exists {
+		previous.timer.Stop()
+	}
+	share.timer = time.AfterFunc(duration, func() { t.expire(key, share) })
+	t.shares[key] = share
+	resource := share.resource(LiveLocationStarted)
+	t.mu.Unlock()
+
+	broadcastLiveLocation(room, resource)
+}
+
+// Update records the new location of a running share, updates received
+// faster than liveLocationThrottle are dropped.
+func (t *LiveLocationTracker) Update(roomID string, u *User, location LocationResource) error {
+	key := liveLocationKey(roomID, u.ID)
+
+	t.mu.Lock()
+	share, exists := t.shares[key]
+	if !exists {
+		t.mu.Unlock()
+		return fiber.NewError(fiber.StatusNotFound, "no live location is being shared in this room")
+	}
+	if time.Since(share.updatedAt) < liveLocationThrottle {
+		t.mu.Unlock()
+		return nil
+	}
+	share.location = location
+	share.updatedAt = time.Now()
+	resource := share.resource(LiveLocationUpdated)
+	t.mu.Unlock()
+
+	broadcastLiveLocation(share.room, resource)
+	return nil
+}
+
+// Stop ends the share of the user in the room, if any.
+func (t *LiveLocationTracker) Stop(roomID string, u *User) {
+	key := liveLocationKey(roomID, u.ID)
+
+	t.mu.Lock()
+	share, exists := t.shares[key]
+	if exists {
+		share.timer.Stop()
+		delete(t.shares, key)
+	}
+	t.mu.Unlock()
+
+	if exists {
+		broadcastLiveLocation(share.room, share.resource(LiveLocationStopped))
+	}
+}
+
+// Active returns the running shares of the room, the oldest first.
+func (t *LiveLocationTracker) Active(roomID UUID) []LiveLocationResource {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := []LiveLocationResource{}
+	for _, share := range t.shares {
+		if share.room.ID == roomID {
+			out = append(out, share.resource(LiveLocationUpdated))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].StartedAt.Before(out[j].StartedAt) })
+	return out
+}
+
+func (t *LiveLocationTracker) expire(key string, share *liveLocationShare) {
+	t.mu.Lock()
+	// the share may have been stopped, or replaced, while the timer was firing
+	if current, exists := t.shares[key]; !exists || current != share {
+		t.mu.Unlock()
+		return
+	}
+	delete(t.shares, key)
+	t.mu.Unlock()
+
+	broadcastLiveLocation(share.room, share.resource(LiveLocationExpired))
+}
+
+// broadcastLiveLocation sends the event to every member of the room, the
+// other connections of the sharing user included.
+func broadcastLiveLocation(room ChatRoom, resource LiveLocationResource) {
+	BroadcastWSMassage(room.UsersIDs, func(userId string) WSClientEventMessage {
+		return WSClientEventMessage{
+			Type:      WSLiveLocationEvent,
+			DataModel: resource,
+		}
+	})
+}
+
+func GetRoomLiveLocations(u *User, roomID string) ([]LiveLocationResource, error) {
+	room, err := getRoomForMember(DB(), roomID, u)
+	if err != nil {
+		return nil, err
+	}
+	return liveLocationTracker.Active(room.ID), nil
+}
+
+type SSELiveLocation struct {
+	ChatRoomID      string           `json:"room_id"`
+	DurationSeconds int              `json:"duration_seconds"` // only for live_location_start
+	Location        *LocationPayload `json:"location"`
+}
+
+func handleLiveLocationEvent(clientConn *WSClientSocket, event SSEType, data SSELiveLocation) error {
+	currentUser := clientConn.user
+
+	if event == SSELiveLocationStopEvent {
+		liveLocationTracker.Stop(data.ChatRoomID, currentUser)
+		return nil
+	}
+
+	if data.Location == nil {
+		return fiber.NewError(fiber.StatusUnprocessableEntity, "location is required")
+	}
+	if err := Validate(data.Location); err != nil {
+		return err
+	}
+	location := *newLocationResource(newMessageLocation(0, data.Location))
+
+	if event == SSELiveLocationUpdateEvent {
+		return liveLocationTracker.Update(data.ChatRoomID, currentUser, location)
+	}
+
+	duration := time.Duration(data.DurationSeconds) * time.Second
+	if duration < liveLocationMinDuration || duration > liveLocationMaxDuration {
+		return fiber.NewError(fiber.StatusUnprocessableEntity, "duration_seconds must be between 60 and 28800")
+	}
+	room, err := getRoomForMember(DB(), data.ChatRoomID, currentUser)
+	if err != nil {
+		return err
+	}
+	liveLocationTracker.Start(*room, currentUser, duration, location)
+	return nil
+}
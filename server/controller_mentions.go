@@ -0,0 +1,237 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const mentionAllKeyword = "all"
+
+type MentionResource struct {
+	UserID      *UUID `json:"user_id,omitempty"` // not set for @all mentions
+	MentionsAll bool  `json:"mentions_all"`
+	Position    int   `json:"position"`
+	Length      int   `json:"length"`
+}
+
+type MentionEventResource struct {
+	RoomID  UUID                `json:"room_id"`
+	Message SentMessageResource `json:"message"`
+}
+
+type mentionMatch struct {
+	userID   *UUID // nil for @all
+	position int
+	length   int
+}
+
+// parseMentions finds the `@name` mentions of the members in the content, the
+// longest matching name wins. `@all` is only matched if allowAll is set.
+// Positions and lengths are in runes.
+func parseMentions(content string, members []User, allowAll bool) []mentionMatch {
+	type candidate struct {
+		name   []rune
+		userID *UUID
+	}
+	candidates := []candidate{}
+	for i := range members {
+		if name := strings.TrimSpace(members[i].Name); name != "" {
+			candidates = append(candidates, candidate{name: []rune(strings.ToLower(name)), userID: &members[i].ID})
+		}
+	}
+	if allowAll {
+		candidates = append(candidates, candidate{name: []rune(mentionAllKeyword)})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return len(candidates[i].name) > len(candidates[j].name) })
+
+	text := []rune(strings.ToLower(content))
+	out := []mentionMatch{}
+	for i := 0; i < len(text); i++ {
+		if text[i] != '@' || (i > 0 && isMentionRune(text[i-1])) {
+			continue
+		}
+		for _, c := range candidates {
+			end := i + 1 + len(c.name)
+			if end > len(text) || string(text[i+1:end]) != string(c.name) {
+				continue
+			}
+			if end < len(text) && isMentionRune(text[end]) {
+				continue
+			}
+			out = append(out, mentionMatch{userID: c.userID, position: i, length: end - i})
+			i = end - 1
+			break
+		}
+	}
+	return out
+}
+
+func isMentionRune(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' }
+
+// saveMessageMentions replaces the stored mentions of the message with the
+// ones found in its content. Only group room admins may mention @all.
+func saveMessageMentions(tx *gorm.DB, msg *ChatMessage, room *ChatRoom) ([]ChatMessageMention, error) {
+	senderID := msg.CreatedByID.String()
+	membersIds := []string{}
+	for _, id := range room.UsersIDs {
+		if id != senderID {
+			membersIds = append(membersIds, id)
+		}
+	}
+	members := []User{}
+	if len(membersIds) > 0 {
+		if err := tx.Select("id", "name").
+			Where("id IN ?", membersIds).
+			Find(&members).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	allowAll := !room.IsPrivate() && room.IsAdmin(senderID)
+	matches := parseMentions(msg.Content, members, allowAll)
+
+	if err := tx.Where("chat_message_id = ?", msg.ID).
+		Delete(&ChatMessageMention{}).Error; err != nil {
+		return nil, err
+	}
+
+	mentions := []ChatMessageMention{}
+	for _, m := range matches {
+		if m.userID != nil {
+			mentions = append(mentions, ChatMessageMention{
+				ChatMessageID: msg.ID,
+				ChatRoomID:    room.ID,
+				UserID:        *m.userID,
+				Position:      m.position,
+				Length:        m.length,
+			})
+			continue
+		}
+		for _, member := range members {
+			mentions = append(mentions, ChatMessageMention{
+				ChatMessageID: msg.ID,
+				ChatRoomID:    room.ID,
+				UserID:        member.ID,
+				MentionsAll:   true,
+				Position:      m.position,
+				Length:        m.length,
+			})
+		}
+	}
+	if len(mentions) == 0 {
+		return mentions, nil
+	}
+	if err := tx.Create(&mentions).Error; err != nil {
+		return nil, err
+	}
+	return mentions, nil
+}
+
+// mentionedUsersIDs returns the distinct ids of the mentioned users.
+func mentionedUsersIDs(mentions []ChatMessageMention) []string {
+	out := []string{}
+	seen := map[string]bool{}
+	for _, v := range mentions {
+		id := v.UserID.String()
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// summarizeMentions collapses the stored mentions of a message into one
+// entity per mention in the content, @all mentions are stored per member.
+func summarizeMentions(mentions []ChatMessageMention) []MentionResource {
+	out := []MentionResource{}
+	seen := map[int]bool{}
+	for _, v := range mentions {
+		if seen[v.Position] {
+			continue
+		}
+		seen[v.Position] = true
+		resource := MentionResource{
+			MentionsAll: v.MentionsAll,
+			Position:    v.Position,
+			Length:      v.Length,
+		}
+		if !v.MentionsAll {
+			userID := v.UserID
+			resource.UserID = &userID
+		}
+		out = append(out, resource)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Position < out[j].Position })
+	return out
+}
+
+// loadMessagesMentions returns the mentions of the messages indexed by message id.
+func loadMessagesMentions(messagesIDs []uint) (map[uint][]ChatMessageMention, error) {
+	out := map[uint][]ChatMessageMention{}
+	if len(messagesIDs) == 0 {
+		return out, nil
+	}
+	mentions := []ChatMessageMention{}
+	if err := DB().Where("chat_message_id IN ?", messagesIDs).
+		Find(&mentions).Error; err != nil {
+		return nil, err
+	}
+	for _, v := range mentions {
+		out[v.ChatMessageID] = append(out[v.ChatMessageID], v)
+	}
+	return out, nil
+}
+
+func broadcastMentions(msg *ChatMessage, sender *User, mentions []ChatMessageMention) {
+	broadcastMentionsTo(mentionedUsersIDs(mentions), msg, sender, mentions)
+}
+
+// broadcastMentionsTo sends the mention event to some of the mentioned users,
+// e.g. only the ones an edit added.
+func broadcastMentionsTo(usersIds []string, msg *ChatMessage, sender *User, mentions []ChatMessageMention) {
+	if len(usersIds) == 0 {
+		return
+	}
+	BroadcastWSMassage(usersIds, func(userId string) WSClientEventMessage {
+		resource := newSentMessageResource(msg, sender, userId)
+		resource.Mentions = summarizeMentions(mentions)
+		return WSClientEventMessage{
+			Type: WSMentionEvent,
+			DataModel: MentionEventResource{
+				RoomID:  msg.ChatRoomID,
+				Message: resource,
+			},
+		}
+	})
+}
+
+// GetMyMentions returns the messages mentioning the user across all of the
+// rooms the user is still a member of, newest first.
+func GetMyMentions(c *fiber.Ctx, u *User) (*PaginatedData[SentMessageResource], error) {
+	if roomID := c.Query("room_id"); roomID != "" {
+		if _, err := UUIDFromString(roomID); err != nil {
+			return nil, fiber.NewError(fiber.StatusUnprocessableEntity, "invalid room_id")
+		}
+	}
+	tx := DB().
+		Where(`EXISTS (SELECT 1 FROM "chat_message_mentions" WHERE "chat_message_mentions"."chat_message_id" = "chat_messages"."id" AND "chat_message_mentions"."user_id" = ?)`, u.ID).
+		Where(`EXISTS (SELECT 1 FROM "chat_rooms" WHERE "chat_rooms"."id" = "chat_messages"."chat_room_id" AND ? = ANY("chat_rooms"."users_ids") AND "chat_rooms"."deleted_at" IS NULL)`, u.ID).
+		Where("chat_messages.deleted_at IS NULL")
+	if roomID := c.Query("room_id"); roomID != "" {
+		tx = tx.Where("chat_messages.chat_room_id = ?", roomID)
+	}
+
+	o, err := PaginateWithCursor(c, ChatMessage{}, tx, messagesCursorKey, func(tx *gorm.DB) *gorm.DB {
+		return preloadMessageContent(tx.Joins("CreatedBy")).Preload("ReplyTo.CreatedBy").Order("created_at DESC")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return transformMessagesPage(o, u)
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type MessageDeletedResource struct {
+	MessageID   uint                 `json:"message_id"`
+	RoomID      UUID                 `json:"room_id"`
+	LastMessage *SentMessageResource `json:"last_message"` // the latest message of the room after the deletion
+}
+
+// getMessageForMember returns the message along with its room, only if the
+// user is a member of the room. Deleted messages are not found.
+func getMessageForMember(tx *gorm.DB, messageID uint, u *User) (*ChatMessage, *ChatRoom, error) {
+	msg := &ChatMessage{}
+	if err := preloadMessageContent(tx.Joins("CreatedBy")).
+		Preload("ReplyTo.CreatedBy").
+		Where("chat_messages.id = ?", messageID).
+		First(msg).Error; err != nil &&
+		!errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, err
+	}
+	if msg.ID == 0 {
+		return nil, nil, fiber.NewError(fiber.StatusNotFound, "message not found")
+	}
+	room, err := getRoomForMember(tx, msg.ChatRoomID.String(), u)
+	if err != nil {
+		return nil, nil, err
+	}
+	return msg, room, nil
+}
+
+// getOwnMessage is getMessageForMember restricted to the messages sent by the user.
+func getOwnMessage(tx *gorm.DB, messageID uint, u *User) (*ChatMessage, *ChatRoom, error) {
+	msg, room, err := getMessageForMember(tx, messageID, u)
+	if err != nil {
+		return nil, nil, err
+	}
+	if msg.CreatedByID != u.ID {
+		return nil, nil, fiber.NewError(fiber.StatusForbidden, "you can only modify your own messages")
+	}
+	return msg, room, nil
+}
+
+type EditMessageInput struct {
+	U         *User
+	MessageID uint
+	Content   string
+}
+
+func EditMessage(in *EditMessageInput) (*SentMessageResource, error) {
+	tx := DB()
+	msg, room, err := getOwnMessage(tx, in.MessageID, in.U)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Type == CMTypePoll || msg.Type == CMTypeSystem {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "polls and system messages can not be edited")
+	}
+	if msg.Content == in.Content {
+		out := newSentMessageResource(msg, in.U, in.U.ID.String())
+		return &out, nil
+	}
+
+	n := time.Now()
+	writtenAt := msg.CreatedAt
+	if msg.EditedAt != nil {
+		writtenAt = *msg.EditedAt
+	}
+	var mentions []ChatMessageMention
+	var previouslyMentioned []string
+	if err := tx.Transaction(func(tx *gorm.DB) error {
+		// keep the replaced version in the history
+		if err := tx.Create(&ChatMessageEdit{
+			ChatMessageID: msg.ID,
+			Content:       msg.Content,
+			WrittenAt:     writtenAt,
+			ReplacedAt:    n,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&ChatMessage{}).
+			Where("id = ?", msg.ID).
+			Updates(map[string]interface{}{
+				"content":   in.Content,
+				"edited_at": n,
+			}).Error; err != nil {
+			return err
+		}
+		msg.Content = in.Content
+		msg.EditedAt = &n
+		if err := tx.Model(&ChatMessageMention{}).
+			Where("chat_message_id = ?", msg.ID).
+			Distinct().
+			Pluck("user_id::TEXT", &previouslyMentioned).Error; err != nil {
+			return err
+		}
+		mentions, err = saveMessageMentions(tx, msg, room)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	BroadcastWSMassage(room.UsersIDs, func(userId string) WSClientEventMessage {
+		resource := newSentMessageResource(msg, in.U, userId)
+		resource.Mentions = summarizeMentions(mentions)
+		return WSClientEventMessage{
+			Type:      WSMessageEditedEvent,
+			DataModel: resource,
+		}
+	})
+
+	// only the users the edit mentions for the first time are notified
+	newlyMentioned := []string{}
+	for _, id := range mentionedUsersIDs(mentions) {
+		if !slices.Contains(previouslyMentioned, id) {
+			newlyMentioned = append(newlyMentioned, id)
+		}
+	}
+	broadcastMentionsTo(newlyMentioned, msg, in.U, mentions)
+
+	out := newSentMessageResource(msg, in.U, in.U.ID.String())
+	out.Mentions = summarizeMentions(mentions)
+	return &out, nil
+}
+
+type MessageEditResource struct {
+	Content    string    `json:"content"`
+	WrittenAt  time.Time `json:"written_at"`
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+type MessageHistoryResource struct {
+	Message SentMessageResource   `json:"message"`
+	History []MessageEditResource `json:"history"` // previous versions, newest first
+}
+
+// GetMessageEditHistory returns the previous versions of the message content,
+// any member of the room can see them.
+func GetMessageEditHistory(u *User, messageID uint) (*MessageHistoryResource, error) {
+	tx := DB()
+	msg, _, err := getMessageForMember(tx, messageID, u)
+	if err != nil {
+		return nil, err
+	}
+	edits := []ChatMessageEdit{}
+	if err := tx.Where("chat_message_id = ?", msg.ID).
+		Order("replaced_at DESC, id DESC").
+		Find(&edits).Error; err != nil {
+		return nil, err
+	}
+	out := &MessageHistoryResource{
+		Message: newSentMessageResource(msg, nil, u.ID.String()),
+		History: make([]MessageEditResource, 0, len(edits)),
+	}
+	for _, v := range edits {
+		out.History = append(out.History, MessageEditResource{
+			Content:    v.Content,
+			WrittenAt:  v.WrittenAt,
+			ReplacedAt: v.ReplacedAt,
+		})
+	}
+	return out, nil
+}
+
+// GetMessageReplies returns the thread of a message, its replies are listed
+// oldest first. The thread of a reply is the thread of its root, even if the
+// root was deleted.
+func GetMessageReplies(c *fiber.Ctx, u *User, messageID uint) (*PaginatedData[SentMessageResource], error) {
+	tx := DB()
+	// the replies outlive their root, the thread is still listed once the
+	// root or the requested message is deleted
+	msg := &ChatMessage{}
+	if err := tx.Unscoped().
+		Select("id", "chat_room_id", "thread_root_id").
+		Where("id = ?", messageID).
+		First(msg).Error; err != nil &&
+		!errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if msg.ID == 0 {
+		return nil, fiber.NewError(fiber.StatusNotFound, "message not found")
+	}
+	if _, err := getRoomForMember(tx, msg.ChatRoomID.String(), u); err != nil {
+		return nil, err
+	}
+	rootID := msg.ID
+	if msg.ThreadRootID != nil {
+		rootID = *msg.ThreadRootID
+	}
+
+	txx := tx.Where("thread_root_id = ?", rootID).
+		Where("chat_messages.deleted_at IS NULL")
+
+	o, err := PaginateWithCursor(c, ChatMessage{}, txx, repliesCursorKey, func(tx *gorm.DB) *gorm.DB {
+		return preloadMessageContent(tx.Joins("CreatedBy")).Order("chat_messages.created_at ASC, chat_messages.id ASC")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return transformMessagesPage(o, u)
+}
+
+type DeleteMessageInput struct {
+	U         *User
+	MessageID uint
+}
+
+func DeleteMessage(in *DeleteMessageInput) (*MessageDeletedResource, error) {
+	tx := DB()
+	msg, room, err := getOwnMessage(tx, in.MessageID, in.U)
+	if err != nil {
+		return nil, err
+	}
+
+	var latestMessage *ChatMessage
+	if err := tx.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&ChatMessage{}, msg.ID).Error; err != nil {
+			return err
+		}
+		if msg.ThreadRootID != nil {
+			if err := recountThreadReplies(tx, *msg.ThreadRootID); err != nil {
+				return err
+			}
+		}
+		if room.LatestMessageID == nil || *room.LatestMessageID != msg.ID {
+			return nil
+		}
+		latestMessage, err = repointLatestMessage(tx, room.ID)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	// the replay logs must not hand the content out once it is deleted, as
+	// for the expired messages
+	wsEventsLog.Redact(map[uint]UUID{msg.ID: room.ID})
+	broadcastMessageDeleted(room, msg.ID, latestMessage)
+
+	return newMessageDeletedResource(room.ID, msg.ID, latestMessage, in.U.ID.String()), nil
+}
+
+func newMessageDeletedResource(roomID UUID, messageID uint, latestMessage *ChatMessage, viewerID string) *MessageDeletedResource {
+	out := &MessageDeletedResource{MessageID: messageID, RoomID: roomID}
+	if latestMessage != nil {
+		lastMessage := newSentMessageResource(latestMessage, nil, viewerID)
+		out.LastMessage = &lastMessage
+	}
+	return out
+}
+
+func broadcastMessageDeleted(room *ChatRoom, messageID uint, latestMessage *ChatMessage) {
+	BroadcastWSMassage(room.UsersIDs, func(userId string) WSClientEventMessage {
+		return WSClientEventMessage{
+			Type:      WSMessageDeletedEvent,
+			DataModel: *newMessageDeletedResource(room.ID, messageID, latestMessage, userId),
+		}
+	})
+}
+
+// repointLatestMessage points the room to its latest remaining message, or to
+// nothing if the room has no messages left. Returns the new latest message.
+func repointLatestMessage(tx *gorm.DB, roomID UUID) (*ChatMessage, error) {
+	latestMessage := &ChatMessage{}
+	if err := tx.Joins("CreatedBy").
+		Where("chat_messages.chat_room_id = ?", roomID).
+		Order("chat_messages.id DESC").
+		First(latestMessage).Error; err != nil &&
+		!errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	var latestMessageID *uint
+	if latestMessage.ID != 0 {
+		latestMessageID = &latestMessage.ID
+	} else {
+		latestMessage = nil
+	}
+	if err := tx.Model(&ChatRoom{}).
+		Where("id = ?", roomID).
+		UpdateColumn("latest_message_id", latestMessageID).Error; err != nil {
+		return nil, err
+	}
+	return latestMessage, nil
+}
+
+// recountThreadReplies sets the reply counters of the root of a thread from
+// its remaining replies.
+func recountThreadReplies(tx *gorm.DB, rootID uint) error {
+	replies := tx.Model(&ChatMessage{}).Where("thread_root_id = ?", rootID)
+	return tx.Model(&ChatMessage{}).
+		Where("id = ?", rootID).
+		UpdateColumns(map[string]interface{}{
+			"reply_count":   replies.Session(&gorm.Session{}).Select("COUNT(*)"),
+			"last_reply_at": replies.Session(&gorm.Session{}).Select("MAX(created_at)"),
+		}).Error
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const maxPinnedMessages = 50 // per room
+
+type PinAction string
+
+const (
+	PinAdded   PinAction = "pinned"
+	PinRemoved PinAction = "unpinned"
+)
+
+type PinResource struct {
+	MessageID   uint      `json:"message_id"`
+	RoomID      UUID      `json:"room_id"`
+	UserID      UUID      `json:"user_id"`
+	Action      PinAction `json:"action"`
+	At          time.Time `json:"at"`
+	PinnedCount int       `json:"pinned_count"` // the pinned messages of the room after the change
+}
+
+type PinnedMessageResource struct {
+	Message    SentMessageResource `json:"message"`
+	PinnedByID *UUID               `json:"pinned_by_id"`
+	PinnedAt   time.Time           `json:"pinned_at"`
+}
+
+// canManagePins reports whether the user can pin messages in the room, either
+// participant of a private chat or the admins of a group.
+func canManagePins(room *ChatRoom, u *User) bool {
+	return room.IsPrivate() || room.IsAdmin(u.ID.String())
+}
+
+type PinMessageInput struct {
+	U         *User
+	RoomID    string
+	MessageID uint
+	Action    PinAction
+}
+
+// PinMessage pins or unpins a message of the room. The members are notified
+// with a pin event, and a system message is written in the room.
+func PinMessage(in *PinMessageInput) (*PinResource, error) {
+	tx := DB()
+	room, err := getRoomForMember(tx, in.RoomID, in.U)
+	if err != nil {
+		return nil, err
+	}
+	if !canManagePins(room, in.U) {
+		return nil, fiber.NewError(fiber.StatusForbidden, "only the admins can pin messages in this room")
+	}
+	msg, _, err := getMessageForMember(tx, in.MessageID, in.U)
+	if err != nil {
+		return nil, err
+	}
+	if msg.ChatRoomID != room.ID {
+		return nil, fiber.NewError(fiber.StatusNotFound, "message not found in this room")
+	}
+	if msg.Type == CMTypeSystem {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "system messages can not be pinned")
+	}
+
+	n := time.Now()
+	var systemMessage *ChatMessage
+	var pinnedCount int
+	if err := tx.Transaction(func(tx *gorm.DB) error {
+		// the pins of the room are changed one at a time, concurrent pins
+		// could both pass the limit otherwise
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id").
+			Where("id = ?", room.ID).
+			First(&ChatRoom{}).Error; err != nil {
+			return err
+		}
+		event, content := SystemEventMessagePinned, in.U.Name+" pinned a message"
+		switch in.Action {
+		case PinAdded:
+			counts, err := pinnedCountsByRoom(tx, []UUID{room.ID})
+			if err != nil {
+				return err
+			}
+			if counts[room.ID.String()] >= maxPinnedMessages {
+				return fiber.NewError(fiber.StatusConflict, "too many pinned messages in this room")
+			}
+			rs := tx.Clauses(clause.OnConflict{DoNothing: true}).
+				Create(&ChatRoomPin{
+					ChatMessageID: msg.ID,
+					ChatRoomID:    room.ID,
+					PinnedByID:    &in.U.ID,
+					PinnedAt:      n,
+				})
+			if rs.Error != nil {
+				return rs.Error
+			}
+			if rs.RowsAffected == 0 {
+				return fiber.NewError(fiber.StatusConflict, "the message is already pinned")
+			}
+		case PinRemoved:
+			rs := tx.Where("chat_message_id = ?", msg.ID).
+				Delete(&ChatRoomPin{})
+			if rs.Error != nil {
+				return rs.Error
+			}
+			if rs.RowsAffected == 0 {
+				return fiber.NewError(fiber.StatusNotFound, "the message is not pinned")
+			}
+			event, content = SystemEventMessageUnpinned, in.U.Name+" unpinned a message"
+		}
+		counts, err := pinnedCountsByRoom(tx, []UUID{room.ID})
+		if err != nil {
+			return err
+		}
+		pinnedCount = counts[room.ID.String()]
+		systemMessage, err = createSystemMessage(tx, room, in.U, event, msg.ID, content)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	out := &PinResource{
+		MessageID:   msg.ID,
+		RoomID:      room.ID,
+		UserID:      in.U.ID,
+		Action:      in.Action,
+		At:          n,
+		PinnedCount: pinnedCount,
+	}
+	BroadcastWSMassage(room.UsersIDs, func(userId string) WSClientEventMessage {
+		return WSClientEventMessage{
+			Type:      WSPinEvent,
+			DataModel: *out,
+		}
+	})
+	broadcastNewMessage(&newMessageOutput{Room: *room, Message: *systemMessage}, in.U)
+	return out, nil
+}
+
+// createSystemMessage writes a message of the server in the room, on behalf
+// of the user who triggered the event. It becomes the latest message of the
+// room, and disappears like the other messages of the room.
+func createSystemMessage(tx *gorm.DB, room *ChatRoom, actor *User, event SystemEvent, targetMessageID uint, content string) (*ChatMessage, error) {
+	msg := &ChatMessage{
+		ChatRoomID:      room.ID,
+		CreatedByID:     actor.ID,
+		Content:         content,
+		Type:            CMTypeSystem,
+		SystemEvent:     &event,
+		TargetMessageID: &targetMessageID,
+	}
+	if ttl := messageTTL(room, nil); ttl > 0 {
+		expiresAt := time.Now().Add(ttl)
+		msg.ExpiresAt = &expiresAt
+	}
+	if err := tx.Create(msg).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&ChatRoom{}).
+		Where("id = ?", room.ID).
+		UpdateColumn("latest_message_id", msg.ID).Error; err != nil {
+		return nil, err
+	}
+	room.LatestMessageID = &msg.ID
+	msg.CreatedBy = actor
+	return msg, nil
+}
+
+// GetRoomPinnedMessages lists the pinned messages of the room, the latest
+// pinned first.
+func GetRoomPinnedMessages(c *fiber.Ctx, u *User, roomID string) (*PaginatedData[PinnedMessageResource], error) {
+	room, err := getRoomForMember(DB(), roomID, u)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := DB().
+		Joins(`JOIN "chat_room_pins" ON "chat_room_pins"."chat_message_id" = "chat_messages"."id"`).
+		Where("chat_room_pins.chat_room_id = ?", room.ID).
+		Where("chat_messages.deleted_at IS NULL")
+
+	o, err := Paginate(c, ChatMessage{}, tx, func(tx *gorm.DB) *gorm.DB {
+		return preloadMessageContent(tx.Joins("CreatedBy")).
+			Preload("ReplyTo.CreatedBy").
+			Order("chat_room_pins.pinned_at DESC, chat_room_pins.chat_message_id DESC")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	messagesIds := []uint{}
+	for _, v := range o.Data {
+		messagesIds = append(messagesIds, v.ID)
+	}
+	pins := []ChatRoomPin{}
+	if len(messagesIds) > 0 {
+		if err := DB().Where("chat_message_id IN ?", messagesIds).
+			Find(&pins).Error; err != nil {
+			return nil, err
+		}
+	}
+	pinsIndexed := map[uint]ChatRoomPin{}
+	for _, v := range pins {
+		pinsIndexed[v.ChatMessageID] = v
+	}
+
+	messages, err := transformMessagesPage(o, u)
+	if err != nil {
+		return nil, err
+	}
+	return TransformPaginatedData(messages, func(data SentMessageResource) (PinnedMessageResource, error) {
+		pin := pinsIndexed[data.ID]
+		return PinnedMessageResource{
+			Message:    data,
+			PinnedByID: pin.PinnedByID,
+			PinnedAt:   pin.PinnedAt,
+		}, nil
+	})
+}
+
+// pinnedCountsByRoom returns the number of pinned messages of the rooms that
+// were not deleted, indexed by room id.
+func pinnedCountsByRoom(tx *gorm.DB, roomsIDs []UUID) (map[string]int, error) {
+	out := map[string]int{}
+	if len(roomsIDs) == 0 {
+		return out, nil
+	}
+	type row struct {
+		ChatRoomID UUID
+		Count      int
+	}
+	rows := []row{}
+	if err := tx.Table("chat_room_pins").
+		Select("chat_room_pins.chat_room_id, COUNT(*) AS count").
+		Joins(`JOIN "chat_messages" ON "chat_messages"."id" = "chat_room_pins"."chat_message_id"`).
+		Where("chat_room_pins.chat_room_id IN ?", roomsIDs).
+		Where("chat_messages.deleted_at IS NULL").
+		Group("chat_room_pins.chat_room_id").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, v := range rows {
+		out[v.ChatRoomID.String()] = v.Count
+	}
+	return out, nil
+}
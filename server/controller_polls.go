@@ -0,0 +1,253 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PollPayload is the poll sent by the clients along with a message.
+type PollPayload struct {
+	Question       string     `json:"question" validate:"required,max=255"`
+	Options        []string   `json:"options" validate:"required,min=2,max=12,unique,dive,required,max=100"`
+	MultipleChoice bool       `json:"multiple_choice"`
+	Anonymous      bool       `json:"anonymous"`
+	ClosesAt       *time.Time `json:"closes_at"`
+}
+
+type PollOptionResource struct {
+	ID     uint   `json:"id"`
+	Text   string `json:"text"`
+	Votes  int    `json:"votes"`
+	Voters []UUID `json:"voters,omitempty"` // only for the polls that are not anonymous
+}
+
+type PollResource struct {
+	Question       string               `json:"question"`
+	MultipleChoice bool                 `json:"multiple_choice"`
+	Anonymous      bool                 `json:"anonymous"`
+	Options        []PollOptionResource `json:"options"`
+	TotalVoters    int                  `json:"total_voters"`
+	MyVotes        []uint               `json:"my_votes"` // the options the viewer voted for
+	Closed         bool                 `json:"closed"`
+	ClosesAt       *time.Time           `json:"closes_at"`
+	ClosedAt       *time.Time           `json:"closed_at"`
+}
+
+// newPollResource builds the tallies of the poll as seen by the viewer, the
+// options must be loaded.
+func newPollResource(p *Poll, votes []PollVote, viewerID string) *PollResource {
+	out := &PollResource{
+		Question:       p.Question,
+		MultipleChoice: p.MultipleChoice,
+		Anonymous:      p.Anonymous,
+		Options:        []PollOptionResource{},
+		MyVotes:        []uint{},
+		Closed:         p.IsClosed(),
+		ClosesAt:       p.ClosesAt,
+		ClosedAt:       p.ClosedAt,
+	}
+	votesByOption := map[uint][]PollVote{}
+	voters := map[UUID]bool{}
+	for _, v := range votes {
+		votesByOption[v.PollOptionID] = append(votesByOption[v.PollOptionID], v)
+		voters[v.UserID] = true
+		if v.UserID.String() == viewerID {
+			out.MyVotes = append(out.MyVotes, v.PollOptionID)
+		}
+	}
+	out.TotalVoters = len(voters)
+	for _, o := range p.Options {
+		option := PollOptionResource{
+			ID:    o.ID,
+			Text:  o.Text,
+			Votes: len(votesByOption[o.ID]),
+		}
+		if !p.Anonymous {
+			for _, v := range votesByOption[o.ID] {
+				option.Voters = append(option.Voters, v.UserID)
+			}
+		}
+		out.Options = append(out.Options, option)
+	}
+	return out
+}
+
+// newMessagePoll builds the poll of a message from a validated payload.
+func newMessagePoll(messageID uint, p *PollPayload) (*Poll, error) {
+	if p.ClosesAt != nil && !p.ClosesAt.After(time.Now()) {
+		return nil, fiber.NewError(fiber.StatusUnprocessableEntity, "closes_at must be in the future")
+	}
+	out := &Poll{
+		ChatMessageID:  messageID,
+		Question:       strings.TrimSpace(p.Question),
+		MultipleChoice: p.MultipleChoice,
+		Anonymous:      p.Anonymous,
+		ClosesAt:       p.ClosesAt,
+	}
+	for i, text := range p.Options {
+		out.Options = append(out.Options, PollOption{
+			ChatMessageID: messageID,
+			Position:      i,
+			Text:          strings.TrimSpace(text),
+		})
+	}
+	return out, nil
+}
+
+// loadPollsVotes returns the votes of the polls indexed by message id.
+func loadPollsVotes(messagesIDs []uint) (map[uint][]PollVote, error) {
+	out := map[uint][]PollVote{}
+	if len(messagesIDs) == 0 {
+		return out, nil
+	}
+	votes := []PollVote{}
+	if err := DB().Where("chat_message_id IN ?", messagesIDs).
+		Order("voted_at ASC").
+		Find(&votes).Error; err != nil {
+		return nil, err
+	}
+	for _, v := range votes {
+		out[v.ChatMessageID] = append(out[v.ChatMessageID], v)
+	}
+	return out, nil
+}
+
+type PollUpdatedResource struct {
+	MessageID uint         `json:"message_id"`
+	RoomID    UUID         `json:"room_id"`
+	Poll      PollResource `json:"poll"`
+}
+
+// getPollForMember returns the poll of a message of a room the user is a
+// member of, with its options.
+func getPollForMember(tx *gorm.DB, messageID uint, u *User) (*ChatMessage, *ChatRoom, error) {
+	msg, room, err := getMessageForMember(tx, messageID, u)
+	if err != nil {
+		return nil, nil, err
+	}
+	if msg.Type != CMTypePoll || msg.Poll == nil {
+		return nil, nil, fiber.NewError(fiber.StatusBadRequest, "the message is not a poll")
+	}
+	return msg, room, nil
+}
+
+type VotePollInput struct {
+	U         *User
+	MessageID uint
+	OptionIDs []uint // replaces the previous votes of the user, empty to retract them
+}
+
+// VotePoll records the votes of the user and sends the new tallies to the
+// members of the room.
+func VotePoll(in *VotePollInput) (*PollUpdatedResource, error) {
+	tx := DB()
+	msg, room, err := getPollForMember(tx, in.MessageID, in.U)
+	if err != nil {
+		return nil, err
+	}
+	poll := msg.Poll
+	if !poll.MultipleChoice && len(in.OptionIDs) > 1 {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "only one option can be chosen in this poll")
+	}
+	options := map[uint]bool{}
+	for _, o := range poll.Options {
+		options[o.ID] = true
+	}
+	for _, id := range in.OptionIDs {
+		if !options[id] {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "option not found in this poll")
+		}
+	}
+
+	if err := tx.Transaction(func(tx *gorm.DB) error {
+		// the votes on the poll are serialized, so that a single choice poll
+		// never gets two votes from concurrent requests
+		locked := &Poll{}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("chat_message_id = ?", poll.ChatMessageID).
+			First(locked).Error; err != nil {
+			return err
+		}
+		if locked.IsClosed() {
+			return fiber.NewError(fiber.StatusConflict, "the poll is closed")
+		}
+		if err := tx.Where("chat_message_id = ?", poll.ChatMessageID).
+			Where("user_id = ?", in.U.ID).
+			Delete(&PollVote{}).Error; err != nil {
+			return err
+		}
+		n := time.Now()
+		for _, id := range in.OptionIDs {
+			if err := tx.Create(&PollVote{
+				PollOptionID:  id,
+				UserID:        in.U.ID,
+				ChatMessageID: poll.ChatMessageID,
+				VotedAt:       n,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return broadcastPollUpdated(msg, room, in.U)
+}
+
+// ClosePoll closes the poll before its closing time, only its creator can.
+func ClosePoll(u *User, messageID uint) (*PollUpdatedResource, error) {
+	tx := DB()
+	msg, room, err := getPollForMember(tx, messageID, u)
+	if err != nil {
+		return nil, err
+	}
+	if msg.CreatedByID != u.ID {
+		return nil, fiber.NewError(fiber.StatusForbidden, "only the creator of the poll can close it")
+	}
+	if msg.Poll.IsClosed() {
+		return nil, fiber.NewError(fiber.StatusConflict, "the poll is already closed")
+	}
+	n := time.Now()
+	if err := tx.Model(&Poll{}).
+		Where("chat_message_id = ?", msg.ID).
+		UpdateColumn("closed_at", n).Error; err != nil {
+		return nil, err
+	}
+	msg.Poll.ClosedAt = &n
+
+	return broadcastPollUpdated(msg, room, u)
+}
+
+// broadcastPollUpdated sends the current tallies to the members of the room,
+// as seen by each of them. Returns the tallies as seen by the user.
+func broadcastPollUpdated(msg *ChatMessage, room *ChatRoom, u *User) (*PollUpdatedResource, error) {
+	votes, err := loadPollsVotes([]uint{msg.ID})
+	if err != nil {
+		return nil, err
+	}
+	newResource := func(viewerID string) PollUpdatedResource {
+		return PollUpdatedResource{
+			MessageID: msg.ID,
+			RoomID:    room.ID,
+			Poll:      *newPollResource(msg.Poll, votes[msg.ID], viewerID),
+		}
+	}
+	BroadcastWSMassage(room.UsersIDs, func(userId string) WSClientEventMessage {
+		return WSClientEventMessage{
+			Type:      WSPollUpdatedEvent,
+			DataModel: newResource(userId),
+		}
+	})
+	out := newResource(u.ID.String())
+	return &out, nil
+}
+
+type SSEPollVote struct {
+	MessageID uint   `json:"message_id"`
+	OptionIDs []uint `json:"option_ids"`
+}
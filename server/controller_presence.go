@@ -0,0 +1,102 @@
+package main
+
+import (
+	"time"
+)
+
+type PresenceResource struct {
+	UserID     UUID       `json:"user_id"`
+	Online     bool       `json:"online"`
+	LastSeenAt *time.Time `json:"last_seen_at"`
+}
+
+// UserWentOnline is called once the first socket of the user is added to the pool.
+func UserWentOnline(u *User) {
+	// the user may have disconnected again before we got here
+	if !wsClientsPool.IsOnline(u.ID.String()) {
+		return
+	}
+	broadcastPresence(u, true, u.LastSeenAt)
+}
+
+// UserWentOffline is called once the last socket of the user is removed from the pool.
+func UserWentOffline(u *User) {
+	// the user may have reconnected before we got here
+	if wsClientsPool.IsOnline(u.ID.String()) {
+		return
+	}
+	n := time.Now()
+	if err := DB().Model(&User{}).
+		Where("id = ?", u.ID).
+		UpdateColumn("last_seen_at", n).Error; err != nil {
+		AppLogger.WithError(err).WithField("user_id", u.ID).Error("failed to update user last seen")
+	}
+	broadcastPresence(u, false, &n)
+}
+
+func broadcastPresence(u *User, online bool, lastSeenAt *time.Time) {
+	usersIds, err := usersSharingRoomsWith(u.ID)
+	if err != nil {
+		AppLogger.WithError(err).WithField("user_id", u.ID).Error("failed to get user contacts")
+		return
+	}
+	if len(usersIds) == 0 {
+		return
+	}
+	eventType := WSOfflineEvent
+	if online {
+		eventType = WSOnlineEvent
+	}
+	BroadcastWSMassage(usersIds, func(userId string) WSClientEventMessage {
+		return WSClientEventMessage{
+			Type: eventType,
+			DataModel: PresenceResource{
+				UserID:     u.ID,
+				Online:     online,
+				LastSeenAt: lastSeenAt,
+			},
+		}
+	})
+}
+
+// usersSharingRoomsWith returns the ids of every user who shares at least one
+// room with the given user, the user itself is excluded.
+func usersSharingRoomsWith(userID UUID) ([]string, error) {
+	usersIds := []string{}
+	if err := DB().Model(&ChatRoom{}).
+		Where("? = ANY(users_ids)", userID).
+		Distinct().
+		Pluck("UNNEST(users_ids)", &usersIds).Error; err != nil {
+		return nil, err
+	}
+	out := make([]string, 0, len(usersIds))
+	for _, id := range usersIds {
+		if id != userID.String() {
+			out = append(out, id)
+		}
+	}
+	return out, nil
+}
+
+// GetUsersPresence returns the presence of the users who share a room with
+// the user, the same audience the online and offline events reach. The other
+// users are left out of the result.
+func GetUsersPresence(u *User, usersIDs []string) ([]PresenceResource, error) {
+	users := []User{}
+	if err := DB().Select("id", "last_seen_at").
+		Where("id IN ?", usersIDs).
+		Where("id <> ?", u.ID).
+		Where(`EXISTS (SELECT 1 FROM "chat_rooms" WHERE "chat_rooms"."deleted_at" IS NULL AND ? = ANY("chat_rooms"."users_ids") AND "users"."id"::TEXT = ANY("chat_rooms"."users_ids"))`, u.ID).
+		Find(&users).Error; err != nil {
+		return nil, err
+	}
+	out := make([]PresenceResource, 0, len(users))
+	for _, v := range users {
+		out = append(out, PresenceResource{
+			UserID:     v.ID,
+			Online:     wsClientsPool.IsOnline(v.ID.String()),
+			LastSeenAt: v.LastSeenAt,
+		})
+	}
+	return out, nil
+}
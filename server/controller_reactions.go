@@ -0,0 +1,127 @@
+package main
+
+import (
+	"gorm.io/gorm/clause"
+)
+
+type ReactionAction string
+
+const (
+	ReactionAdded   ReactionAction = "added"
+	ReactionRemoved ReactionAction = "removed"
+)
+
+type ReactionSummaryResource struct {
+	Emoji       string `json:"emoji"`
+	Count       int    `json:"count"`
+	ReactedByMe bool   `json:"reacted_by_me"`
+}
+
+type ReactionResource struct {
+	MessageID uint                      `json:"message_id"`
+	RoomID    UUID                      `json:"room_id"`
+	UserID    UUID                      `json:"user_id"`
+	Emoji     string                    `json:"emoji"`
+	Action    ReactionAction            `json:"action"`
+	Reactions []ReactionSummaryResource `json:"reactions"` // the reactions of the message after the change
+}
+
+type ReactToMessageInput struct {
+	U         *User
+	MessageID uint
+	Emoji     string
+	Action    ReactionAction
+}
+
+// ReactToMessage adds or removes a reaction of the user on the message, both
+// operations are idempotent.
+func ReactToMessage(in *ReactToMessageInput) (*ReactionResource, error) {
+	tx := DB()
+	msg, room, err := getMessageForMember(tx, in.MessageID, in.U)
+	if err != nil {
+		return nil, err
+	}
+
+	switch in.Action {
+	case ReactionAdded:
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&ChatMessageReaction{
+				ChatMessageID: msg.ID,
+				UserID:        in.U.ID,
+				Emoji:         in.Emoji,
+			}).Error; err != nil {
+			return nil, err
+		}
+	case ReactionRemoved:
+		if err := tx.Where("chat_message_id = ?", msg.ID).
+			Where("user_id = ?", in.U.ID).
+			Where("emoji = ?", in.Emoji).
+			Delete(&ChatMessageReaction{}).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	reactions, err := loadMessagesReactions([]uint{msg.ID})
+	if err != nil {
+		return nil, err
+	}
+
+	newResource := func(viewerID string) ReactionResource {
+		return ReactionResource{
+			MessageID: msg.ID,
+			RoomID:    room.ID,
+			UserID:    in.U.ID,
+			Emoji:     in.Emoji,
+			Action:    in.Action,
+			Reactions: summarizeReactions(reactions[msg.ID], viewerID),
+		}
+	}
+
+	BroadcastWSMassage(room.UsersIDs, func(userId string) WSClientEventMessage {
+		return WSClientEventMessage{
+			Type:      WSReactionEvent,
+			DataModel: newResource(userId),
+		}
+	})
+
+	out := newResource(in.U.ID.String())
+	return &out, nil
+}
+
+// loadMessagesReactions returns the reactions of the messages indexed by message id.
+func loadMessagesReactions(messagesIDs []uint) (map[uint][]ChatMessageReaction, error) {
+	out := map[uint][]ChatMessageReaction{}
+	if len(messagesIDs) == 0 {
+		return out, nil
+	}
+	reactions := []ChatMessageReaction{}
+	if err := DB().Where("chat_message_id IN ?", messagesIDs).
+		Order("created_at ASC, id ASC").
+		Find(&reactions).Error; err != nil {
+		return nil, err
+	}
+	for _, v := range reactions {
+		out[v.ChatMessageID] = append(out[v.ChatMessageID], v)
+	}
+	return out, nil
+}
+
+// summarizeReactions aggregates the reactions of a message by emoji, in the
+// order each emoji was first used.
+func summarizeReactions(reactions []ChatMessageReaction, viewerID string) []ReactionSummaryResource {
+	out := []ReactionSummaryResource{}
+	indexed := map[string]int{}
+	for _, v := range reactions {
+		i, exists := indexed[v.Emoji]
+		if !exists {
+			i = len(out)
+			indexed[v.Emoji] = i
+			out = append(out, ReactionSummaryResource{Emoji: v.Emoji})
+		}
+		out[i].Count++
+		if v.UserID.String() == viewerID {
+			out[i].ReactedByMe = true
+		}
+	}
+	return out
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ReadReceiptResource struct {
+	RoomID            UUID      `json:"room_id"`
+	UserID            UUID      `json:"user_id"`
+	LastReadMessageID uint      `json:"last_read_message_id"`
+	ReadAt            time.Time `json:"read_at"`
+}
+
+type MarkRoomAsReadInput struct {
+	U         *User
+	RoomID    string
+	MessageID uint
+}
+
+// MarkRoomAsRead moves the read pointer of the user in the room forward to the
+// given message, the pointer never moves backwards. The members are only
+// notified when the pointer moved.
+func MarkRoomAsRead(in *MarkRoomAsReadInput) (*ReadReceiptResource, error) {
+	tx := DB()
+	room, err := getRoomForMember(tx, in.RoomID, in.U)
+	if err != nil {
+		return nil, err
+	}
+
+	var count int64
+	if err := tx.Model(&ChatMessage{}).
+		Where("id = ?", in.MessageID).
+		Where("chat_room_id = ?", room.ID).
+		Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "message not found in this room")
+	}
+
+	read := &ChatRoomRead{}
+	moved := false
+	if err := tx.Transaction(func(tx *gorm.DB) error {
+		n := time.Now()
+		// the row is left untouched when the pointer is already there
+		rs := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "chat_room_id"}, {Name: "user_id"}},
+			DoUpdates: clause.Set{
+				{
+					Column: clause.Column{Name: "last_read_message_id"},
+					Value:  gorm.Expr(`EXCLUDED."last_read_message_id"`),
+				},
+				{
+					Column: clause.Column{Name: "read_at"},
+					Value:  n,
+				},
+			},
+			Where: clause.Where{Exprs: []clause.Expression{
+				gorm.Expr(`"chat_room_reads"."last_read_message_id" < EXCLUDED."last_read_message_id"`),
+			}},
+		}).Create(&ChatRoomRead{
+			ChatRoomID:        room.ID,
+			UserID:            in.U.ID,
+			LastReadMessageID: in.MessageID,
+			ReadAt:            n,
+		})
+		if rs.Error != nil {
+			return rs.Error
+		}
+		moved = rs.RowsAffected > 0
+		return tx.Where("chat_room_id = ?", room.ID).
+			Where("user_id = ?", in.U.ID).
+			First(read).Error
+	}); err != nil {
+		return nil, err
+	}
+
+	out := &ReadReceiptResource{
+		RoomID:            read.ChatRoomID,
+		UserID:            read.UserID,
+		LastReadMessageID: read.LastReadMessageID,
+		ReadAt:            read.ReadAt,
+	}
+
+	if !moved {
+		return out, nil
+	}
+	// every member is notified, including the other devices of the reader
+	BroadcastWSMassage(room.UsersIDs, func(userId string) WSClientEventMessage {
+		return WSClientEventMessage{
+			Type:      WSReadEvent,
+			DataModel: *out,
+		}
+	})
+
+	return out, nil
+}
+
+// GetRoomReadReceipts returns the read pointers of every member of the room
+// who read at least one message.
+func GetRoomReadReceipts(u *User, roomID string) ([]ReadReceiptResource, error) {
+	tx := DB()
+	room, err := getRoomForMember(tx, roomID, u)
+	if err != nil {
+		return nil, err
+	}
+	reads := []ChatRoomRead{}
+	if err := tx.Where("chat_room_id = ?", room.ID).
+		Where("user_id::TEXT IN ?", []string(room.UsersIDs)).
+		Find(&reads).Error; err != nil {
+		return nil, err
+	}
+	out := make([]ReadReceiptResource, 0, len(reads))
+	for _, v := range reads {
+		out = append(out, ReadReceiptResource{
+			RoomID:            v.ChatRoomID,
+			UserID:            v.UserID,
+			LastReadMessageID: v.LastReadMessageID,
+			ReadAt:            v.ReadAt,
+		})
+	}
+	return out, nil
+}
+
+// unreadCountsByRoom returns the number of messages sent by others after the
+// user's read pointer, indexed by room id.
+func unreadCountsByRoom(u *User, roomsIDs []UUID) (map[string]int, error) {
+	out := map[string]int{}
+	if len(roomsIDs) == 0 {
+		return out, nil
+	}
+	type row struct {
+		ChatRoomID UUID
+		Count      int
+	}
+	rows := []row{}
+	if err := DB().Table("chat_messages").
+		Select("chat_messages.chat_room_id, COUNT(*) AS count").
+		Joins(`LEFT JOIN "chat_room_reads" ON "chat_room_reads"."chat_room_id" = "chat_messages"."chat_room_id" AND "chat_room_reads"."user_id" = ?`, u.ID).
+		Where("chat_messages.chat_room_id IN ?", roomsIDs).
+		Where("chat_messages.created_by_id <> ?", u.ID).
+		Where("chat_messages.deleted_at IS NULL").
+		Where(`chat_messages.id > COALESCE("chat_room_reads"."last_read_message_id", 0)`).
+		Group("chat_messages.chat_room_id").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, v := range rows {
+		out[v.ChatRoomID.String()] = v.Count
+	}
+	return out, nil
+}
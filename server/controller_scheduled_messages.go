@@ -0,0 +1,395 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	scheduledMessagesInterval   = 5 * time.Second
+	scheduledMessagesBatchSize  = 50
+	maxScheduleAhead            = 365 * 24 * time.Hour
+	maxPendingScheduledMessages = 100 // per user
+)
+
+type ScheduledMessageResource struct {
+	ID            uint                   `json:"id"`
+	RoomID        UUID                   `json:"room_id"`
+	Content       string                 `json:"content"`
+	ReplyToID     *uint                  `json:"reply_to_id,omitempty"`
+	AttachmentID  *UUID                  `json:"attachment_id,omitempty"`
+	PlayOnce      bool                   `json:"play_once"`
+	ScheduledAt   time.Time              `json:"scheduled_at"`
+	Status        ScheduledMessageStatus `json:"status"`
+	Error         *string                `json:"error,omitempty"`
+	SentMessageID *uint                  `json:"sent_message_id,omitempty"`
+	CreatedAt     time.Time              `json:"created_at"`
+	UpdatedAt     time.Time              `json:"updated_at"`
+}
+
+func newScheduledMessageResource(sm *ScheduledMessage) ScheduledMessageResource {
+	return ScheduledMessageResource{
+		ID:            sm.ID,
+		RoomID:        sm.ChatRoomID,
+		Content:       sm.Content,
+		ReplyToID:     sm.ReplyToID,
+		AttachmentID:  sm.AttachmentID,
+		PlayOnce:      sm.PlayOnce,
+		ScheduledAt:   sm.ScheduledAt,
+		Status:        sm.Status,
+		Error:         sm.Error,
+		SentMessageID: sm.SentMessageID,
+		CreatedAt:     sm.CreatedAt,
+		UpdatedAt:     sm.UpdatedAt,
+	}
+}
+
+func validateScheduledAt(scheduledAt time.Time) error {
+	if !scheduledAt.After(time.Now()) {
+		return fiber.NewError(fiber.StatusUnprocessableEntity, "scheduled_at must be in the future")
+	}
+	if scheduledAt.After(time.Now().Add(maxScheduleAhead)) {
+		return fiber.NewError(fiber.StatusUnprocessableEntity, "messages can not be scheduled more than a year ahead")
+	}
+	return nil
+}
+
+type ScheduleMessageInput struct {
+	U            *User
+	RoomID       string
+	Content      string
+	ReplyToID    *uint
+	AttachmentID string
+	PlayOnce     bool
+	ScheduledAt  time.Time
+}
+
+// ScheduleMessage stores a message to be sent to the room later. The message
+// is checked now so that most mistakes are reported to the user right away,
+// it is checked again when it's delivered since the room may change meanwhile.
+func ScheduleMessage(in *ScheduleMessageInput) (*ScheduledMessageResource, error) {
+	tx := DB()
+	room, err := getRoomForMember(tx, in.RoomID, in.U)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateScheduledAt(in.ScheduledAt); err != nil {
+		return nil, err
+	}
+
+	var pending int64
+	if err := tx.Model(&ScheduledMessage{}).
+		Where("created_by_id = ?", in.U.ID).
+		Where("status = ?", SMStatusPending).
+		Count(&pending).Error; err != nil {
+		return nil, err
+	}
+	if pending >= maxPendingScheduledMessages {
+		return nil, fiber.NewError(fiber.StatusTooManyRequests, "too many pending scheduled messages")
+	}
+
+	if in.ReplyToID != nil {
+		var count int64
+		if err := tx.Model(&ChatMessage{}).
+			Where("id = ?", *in.ReplyToID).
+			Where("chat_room_id = ?", room.ID).
+			Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "replied message not found in this room")
+		}
+	}
+
+	sm := &ScheduledMessage{
+		ChatRoomID:  room.ID,
+		CreatedByID: in.U.ID,
+		Content:     in.Content,
+		ReplyToID:   in.ReplyToID,
+		PlayOnce:    in.PlayOnce,
+		ScheduledAt: in.ScheduledAt,
+	}
+	var attachment *Attachment
+	if in.AttachmentID != "" {
+		attachment = &Attachment{}
+		if err := tx.Where("id = ?", in.AttachmentID).
+			Where("uploaded_by_id = ?", in.U.ID).
+			First(attachment).Error; err != nil &&
+			!errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		if attachment.ID.IsEmpty() {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "attachment not found, you can only send your own uploads")
+		}
+		sm.AttachmentID = &attachment.ID
+	} else if strings.TrimSpace(in.Content) == "" {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "content or attachment_id is required")
+	}
+	if in.PlayOnce && (attachment == nil || attachment.MessageType() != CMTypeAudio) {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "only voice notes can be sent as play once")
+	}
+
+	if err := tx.Create(sm).Error; err != nil {
+		return nil, err
+	}
+	out := newScheduledMessageResource(sm)
+	return &out, nil
+}
+
+// GetScheduledMessages lists the scheduled messages of the user that were not
+// sent yet, the failed ones included, the soonest first.
+func GetScheduledMessages(c *fiber.Ctx, u *User, roomID string) (*PaginatedData[ScheduledMessageResource], error) {
+	tx := DB().
+		Where("created_by_id = ?", u.ID).
+		Where("status IN ?", []ScheduledMessageStatus{SMStatusPending, SMStatusFailed})
+	if roomID != "" {
+		tx = tx.Where("chat_room_id = ?", roomID)
+	}
+	o, err := Paginate(c, ScheduledMessage{}, tx, func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("scheduled_at ASC, id ASC")
+	})
+	if err != nil {
+		return nil, err
+	}
+	return TransformPaginatedData(o, func(data ScheduledMessage) (ScheduledMessageResource, error) {
+		return newScheduledMessageResource(&data), nil
+	})
+}
+
+// getPendingScheduledMessage returns a scheduled message of the user that can
+// still be changed.
+func getPendingScheduledMessage(tx *gorm.DB, id uint, u *User) (*ScheduledMessage, error) {
+	sm := &ScheduledMessage{}
+	if err := tx.Where("id = ?", id).
+		Where("created_by_id = ?", u.ID).
+		First(sm).Error; err != nil &&
+		!errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if sm.ID == 0 {
+		return nil, fiber.NewError(fiber.StatusNotFound, "scheduled message not found")
+	}
+	if sm.Status == SMStatusSent {
+		return nil, fiber.NewError(fiber.StatusConflict, "the scheduled message was already sent")
+	}
+	return sm, nil
+}
+
+type UpdateScheduledMessageInput struct {
+	U           *User
+	ID          uint
+	Content     *string
+	ScheduledAt *time.Time
+}
+
+// UpdateScheduledMessage changes the content or the time of a scheduled
+// message, a failed message is scheduled again.
+func UpdateScheduledMessage(in *UpdateScheduledMessageInput) (*ScheduledMessageResource, error) {
+	tx := DB()
+	sm, err := getPendingScheduledMessage(tx, in.ID, in.U)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{
+		"status":     SMStatusPending,
+		"error":      nil,
+		"updated_at": time.Now(),
+	}
+	if in.Content != nil {
+		if strings.TrimSpace(*in.Content) == "" && sm.AttachmentID == nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "content is required")
+		}
+		updates["content"] = *in.Content
+	}
+	scheduledAt := sm.ScheduledAt
+	if in.ScheduledAt != nil {
+		scheduledAt = *in.ScheduledAt
+		updates["scheduled_at"] = scheduledAt
+	}
+	if err := validateScheduledAt(scheduledAt); err != nil {
+		return nil, err
+	}
+
+	// the scheduler may be sending the message right now, it keeps the row
+	// locked until it is marked as sent
+	rs := tx.Model(sm).
+		Where("status <> ?", SMStatusSent).
+		Updates(updates)
+	if rs.Error != nil {
+		return nil, rs.Error
+	}
+	if rs.RowsAffected == 0 {
+		return nil, fiber.NewError(fiber.StatusConflict, "the scheduled message was already sent")
+	}
+	if err := tx.First(sm, sm.ID).Error; err != nil {
+		return nil, err
+	}
+	out := newScheduledMessageResource(sm)
+	return &out, nil
+}
+
+// CancelScheduledMessage deletes a scheduled message that was not sent yet.
+func CancelScheduledMessage(u *User, id uint) error {
+	tx := DB()
+	sm, err := getPendingScheduledMessage(tx, id, u)
+	if err != nil {
+		return err
+	}
+	rs := tx.Where("id = ?", sm.ID).
+		Where("status <> ?", SMStatusSent).
+		Delete(&ScheduledMessage{})
+	if rs.Error != nil {
+		return rs.Error
+	}
+	if rs.RowsAffected == 0 {
+		return fiber.NewError(fiber.StatusConflict, "the scheduled message was already sent")
+	}
+	return nil
+}
+
+// scheduledMessagesLoop delivers the due messages until stop is closed, a
+// batch is never cut between its commit and its broadcasts.
+func scheduledMessagesLoop(stop <-chan struct{}) {
+	ticker := time.NewTicker(scheduledMessagesInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		for {
+			delivered, err := deliverDueScheduledMessages()
+			if err != nil {
+				AppLogger.WithError(err).Error("failed to deliver scheduled messages")
+				break
+			}
+			// a full batch means that more messages may be due
+			if delivered < scheduledMessagesBatchSize || isStopped(stop) {
+				break
+			}
+		}
+	}
+}
+
+type scheduledDelivery struct {
+	out    *newMessageOutput
+	sender *User
+}
+
+// deliverDueScheduledMessages sends a batch of the due messages. The rows are
+// locked with SKIP LOCKED so that several server instances share the work
+// without sending a message twice, every message is created in a savepoint
+// so that a failing one doesn't roll back the others.
+func deliverDueScheduledMessages() (int, error) {
+	due := []ScheduledMessage{}
+	deliveries := []scheduledDelivery{}
+	err := DB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ?", SMStatusPending).
+			Where("scheduled_at <= ?", time.Now()).
+			Order("scheduled_at ASC, id ASC").
+			Limit(scheduledMessagesBatchSize).
+			Find(&due).Error; err != nil {
+			return err
+		}
+		for _, sm := range due {
+			// a deleted sender fails the message, not the batch
+			sender := &User{}
+			if err := tx.Where("id = ?", sm.CreatedByID).First(sender).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+				if err := markScheduledMessageFailed(tx, sm.ID, "the sender no longer exists"); err != nil {
+					return err
+				}
+				continue
+			} else if err != nil {
+				return err
+			}
+			in := &SendMessageInput{
+				U:         sender,
+				RoomID:    sm.ChatRoomID.String(),
+				Content:   sm.Content,
+				ReplyToID: sm.ReplyToID,
+				PlayOnce:  sm.PlayOnce,
+			}
+			if sm.AttachmentID != nil {
+				in.AttachmentID = sm.AttachmentID.String()
+			}
+
+			var out *newMessageOutput
+			sendErr := tx.Transaction(func(tx *gorm.DB) error {
+				var err error
+				out, err = createNewMessageTx(tx, in)
+				return err
+			})
+
+			if sendErr != nil {
+				// the message can not be sent anymore, e.g. the user left the
+				// room, other errors are retried with the whole batch
+				reason := scheduledDeliveryFailure(sendErr)
+				if reason == "" {
+					return sendErr
+				}
+				if err := markScheduledMessageFailed(tx, sm.ID, reason); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := tx.Model(&ScheduledMessage{}).
+				Where("id = ?", sm.ID).
+				Updates(map[string]interface{}{
+					"status":          SMStatusSent,
+					"sent_message_id": out.Message.ID,
+					"updated_at":      time.Now(),
+				}).Error; err != nil {
+				return err
+			}
+			deliveries = append(deliveries, scheduledDelivery{out: out, sender: sender})
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// broadcast once committed, the members must not see a message that
+	// could still be rolled back
+	for _, d := range deliveries {
+		broadcastNewMessage(d.out, d.sender)
+	}
+	return len(due), nil
+}
+
+func markScheduledMessageFailed(tx *gorm.DB, id uint, reason string) error {
+	return tx.Model(&ScheduledMessage{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":     SMStatusFailed,
+			"error":      reason,
+			"updated_at": time.Now(),
+		}).Error
+}
+
+// scheduledDeliveryFailure returns why the scheduled message itself can not be
+// sent, or an empty string if the error is not specific to the message, e.g.
+// the database is unreachable, and the batch has to be retried.
+func scheduledDeliveryFailure(err error) string {
+	var fiberErr *fiber.Error
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.As(err, &fiberErr):
+		return fiberErr.Message
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return "a record the message refers to no longer exists"
+	case errors.As(err, &pgErr) && (strings.HasPrefix(pgErr.Code, "22") || strings.HasPrefix(pgErr.Code, "23")):
+		// data exceptions and integrity constraint violations
+		return "the message could not be saved"
+	}
+	return ""
+}
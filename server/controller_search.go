@@ -0,0 +1,146 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// searchTSConfig is the text search configuration of the content_tsv column,
+// `simple` does not stem words so it behaves the same for every language.
+const searchTSConfig = "simple"
+
+// searchHeadlineOptions are the ts_headline options of the snippets, the
+// content is HTML escaped before the matches are wrapped in <mark> tags.
+const searchHeadlineOptions = "StartSel=<mark>, StopSel=</mark>, MaxWords=30, MinWords=10, MaxFragments=2, FragmentDelimiter= … "
+
+type SearchMessagesInput struct {
+	U        *User
+	Query    string
+	RoomID   string
+	SenderID string
+	Types    []string
+	From     *time.Time
+	To       *time.Time
+	Sort     string // `newest` (default) or `relevance`
+}
+
+type MessageSearchResultResource struct {
+	Message SentMessageResource `json:"message"`
+	Snippet string              `json:"snippet"` // HTML escaped, with the matches wrapped in <mark> tags
+}
+
+// SearchMessages runs a full text search over the messages of the rooms the
+// user is a member of.
+func SearchMessages(c *fiber.Ctx, in *SearchMessagesInput) (*PaginatedData[MessageSearchResultResource], error) {
+	tsQuery := clause.Expr{SQL: "websearch_to_tsquery('" + searchTSConfig + "', ?)", Vars: []interface{}{in.Query}}
+
+	tx := DB().
+		Where("chat_messages.content_tsv @@ ?", tsQuery).
+		Where(`EXISTS (SELECT 1 FROM "chat_rooms" WHERE "chat_rooms"."id" = "chat_messages"."chat_room_id" AND ? = ANY("chat_rooms"."users_ids") AND "chat_rooms"."deleted_at" IS NULL)`, in.U.ID).
+		Where("chat_messages.deleted_at IS NULL").
+		Where("chat_messages.type <> ?", CMTypeSystem)
+	if in.RoomID != "" {
+		tx = tx.Where("chat_messages.chat_room_id = ?", in.RoomID)
+	}
+	if in.SenderID != "" {
+		tx = tx.Where("chat_messages.created_by_id = ?", in.SenderID)
+	}
+	if len(in.Types) > 0 {
+		tx = tx.Where("chat_messages.type IN ?", in.Types)
+	}
+	if in.From != nil {
+		tx = tx.Where("chat_messages.created_at >= ?", *in.From)
+	}
+	if in.To != nil {
+		tx = tx.Where("chat_messages.created_at < ?", *in.To)
+	}
+
+	o, err := Paginate(c, ChatMessage{}, tx, func(tx *gorm.DB) *gorm.DB {
+		tx = preloadMessageContent(tx.Joins("CreatedBy")).Preload("ReplyTo.CreatedBy")
+		if in.Sort == "relevance" {
+			return tx.Order(clause.OrderBy{Expression: clause.Expr{
+				SQL:  "ts_rank(chat_messages.content_tsv, ?) DESC, chat_messages.created_at DESC, chat_messages.id DESC",
+				Vars: []interface{}{tsQuery},
+			}})
+		}
+		return tx.Order("chat_messages.created_at DESC, chat_messages.id DESC")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	snippets, err := searchSnippets(o.Data, tsQuery)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := transformMessagesPage(o, in.U)
+	if err != nil {
+		return nil, err
+	}
+	return TransformPaginatedData(messages, func(data SentMessageResource) (MessageSearchResultResource, error) {
+		return MessageSearchResultResource{Message: data, Snippet: snippets[data.ID]}, nil
+	})
+}
+
+// searchSnippets highlights the matches in the messages of a page, headlines
+// are expensive so they are only computed for the returned messages.
+func searchSnippets(messages []ChatMessage, tsQuery clause.Expr) (map[uint]string, error) {
+	out := map[uint]string{}
+	if len(messages) == 0 {
+		return out, nil
+	}
+	messagesIds := []uint{}
+	for _, v := range messages {
+		messagesIds = append(messagesIds, v.ID)
+	}
+	type row struct {
+		ID      uint
+		Snippet string
+	}
+	rows := []row{}
+	escapedContent := `replace(replace(replace(chat_messages.content, '&', '&amp;'), '<', '&lt;'), '>', '&gt;')`
+	if err := DB().Model(&ChatMessage{}).
+		Select("chat_messages.id, ts_headline('"+searchTSConfig+"', "+escapedContent+", ?, ?) AS snippet", tsQuery, searchHeadlineOptions).
+		Where("chat_messages.id IN ?", messagesIds).
+		Unscoped().
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, v := range rows {
+		out[v.ID] = v.Snippet
+	}
+	return out, nil
+}
+
+// parseSearchTime parses a RFC 3339 time or a date, a date used as the end of
+// a range includes the whole day.
+func parseSearchTime(value string, endOfRange bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusUnprocessableEntity, "invalid date "+value+", expected RFC 3339 or YYYY-MM-DD")
+	}
+	if endOfRange {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
+
+func splitQueryList(value string) []string {
+	out := []string{}
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
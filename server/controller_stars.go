@@ -0,0 +1,148 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type StarredMessageResource struct {
+	Message   SentMessageResource `json:"message"`
+	Note      *string             `json:"note"`
+	StarredAt time.Time           `json:"starred_at"`
+}
+
+type StarMessageInput struct {
+	U         *User
+	MessageID uint
+	Note      *string // replaces the note of a message already starred, nil removes it
+}
+
+// StarMessage saves the message in the starred messages of the user, starring
+// it again only changes the note.
+func StarMessage(in *StarMessageInput) (*StarredMessageResource, error) {
+	tx := DB()
+	msg, _, err := getMessageForMember(tx, in.MessageID, in.U)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Type == CMTypeSystem {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "system messages can not be starred")
+	}
+	var note *string
+	if in.Note != nil {
+		if trimmed := strings.TrimSpace(*in.Note); trimmed != "" {
+			note = &trimmed
+		}
+	}
+
+	star := &ChatMessageStar{
+		ChatMessageID: msg.ID,
+		UserID:        in.U.ID,
+		Note:          note,
+		StarredAt:     time.Now(),
+	}
+	if err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "chat_message_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"note"}),
+	}).Create(star).Error; err != nil {
+		return nil, err
+	}
+	// the star may be older than this request
+	if err := tx.Where("chat_message_id = ?", msg.ID).
+		Where("user_id = ?", in.U.ID).
+		First(star).Error; err != nil {
+		return nil, err
+	}
+
+	resource := newSentMessageResource(msg, nil, in.U.ID.String())
+	resource.Starred = true
+	return &StarredMessageResource{
+		Message:   resource,
+		Note:      star.Note,
+		StarredAt: star.StarredAt,
+	}, nil
+}
+
+// UnstarMessage removes the message from the starred messages of the user,
+// even if the user left its room since.
+func UnstarMessage(u *User, messageID uint) error {
+	rs := DB().Where("chat_message_id = ?", messageID).
+		Where("user_id = ?", u.ID).
+		Delete(&ChatMessageStar{})
+	if rs.Error != nil {
+		return rs.Error
+	}
+	if rs.RowsAffected == 0 {
+		return fiber.NewError(fiber.StatusNotFound, "the message is not starred")
+	}
+	return nil
+}
+
+// GetStarredMessages lists the starred messages of the user, the latest
+// starred first. The messages of the rooms the user is no longer a member of
+// are hidden, they show up again if the user joins back.
+func GetStarredMessages(c *fiber.Ctx, u *User, roomID string) (*PaginatedData[StarredMessageResource], error) {
+	tx := DB().
+		Joins(`JOIN "chat_message_stars" ON "chat_message_stars"."chat_message_id" = "chat_messages"."id" AND "chat_message_stars"."user_id" = ?`, u.ID).
+		Joins(`JOIN "chat_rooms" ON "chat_rooms"."id" = "chat_messages"."chat_room_id"`).
+		Where("? = ANY(chat_rooms.users_ids)", u.ID).
+		Where("chat_rooms.deleted_at IS NULL").
+		Where("chat_messages.deleted_at IS NULL")
+	if roomID != "" {
+		tx = tx.Where("chat_messages.chat_room_id = ?", roomID)
+	}
+
+	o, err := Paginate(c, ChatMessage{}, tx, func(tx *gorm.DB) *gorm.DB {
+		return preloadMessageContent(tx.Joins("CreatedBy")).
+			Preload("ReplyTo.CreatedBy").
+			Order("chat_message_stars.starred_at DESC, chat_message_stars.chat_message_id DESC")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	messagesIds := []uint{}
+	for _, v := range o.Data {
+		messagesIds = append(messagesIds, v.ID)
+	}
+	stars, err := loadMessagesStars(messagesIds, u)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := transformMessagesPage(o, u)
+	if err != nil {
+		return nil, err
+	}
+	return TransformPaginatedData(messages, func(data SentMessageResource) (StarredMessageResource, error) {
+		star := stars[data.ID]
+		return StarredMessageResource{
+			Message:   data,
+			Note:      star.Note,
+			StarredAt: star.StarredAt,
+		}, nil
+	})
+}
+
+// loadMessagesStars returns the stars of the user on the messages indexed by
+// message id, the messages that are not starred are missing.
+func loadMessagesStars(messagesIDs []uint, u *User) (map[uint]ChatMessageStar, error) {
+	out := map[uint]ChatMessageStar{}
+	if len(messagesIDs) == 0 {
+		return out, nil
+	}
+	stars := []ChatMessageStar{}
+	if err := DB().Where("chat_message_id IN ?", messagesIDs).
+		Where("user_id = ?", u.ID).
+		Find(&stars).Error; err != nil {
+		return nil, err
+	}
+	for _, v := range stars {
+		out[v.ChatMessageID] = v
+	}
+	return out, nil
+}
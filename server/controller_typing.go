@@ -0,0 +1,141 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	typingTimeout  = 6 * time.Second // typing state expires if the client never sends a stop event
+	typingThrottle = 2 * time.Second // minimum interval between two typing_start events of the same connection
+)
+
+var (
+	typingTracker = NewTypingTracker()
+)
+
+type TypingResource struct {
+	RoomID   UUID   `json:"room_id"`
+	UserID   UUID   `json:"user_id"`
+	UserName string `json:"user_name"`
+	Typing   bool   `json:"typing"`
+}
+
+type typingState struct {
+	room  ChatRoom
+	user  *User
+	timer *time.Timer
+}
+
+// TypingTracker keeps the in-memory typing state of every user per room,
+// nothing here is persisted.
+type TypingTracker struct {
+	mu     sync.Mutex
+	states map[string]*typingState
+}
+
+func NewTypingTracker() *TypingTracker {
+	return &TypingTracker{states: make(map[string]*typingState)}
+}
+
+func typingKey(roomID UUID, userID UUID) string { return roomID.String() + ":" + userID.String() }
+
+// Start marks the user as typing in the room, the other room members are only
+// notified when the state changes. Calling Start again extends the expiry.
+func (t *TypingTracker) Start(room ChatRoom, u *User) {
+	key := typingKey(room.ID, u.ID)
+
+	t.mu.Lock()
+	if state, exists := t.states[key]; exists {
+		state.timer.Reset(typingTimeout)
+		t.mu.Unlock()
+		return
+	}
+	state := &typingState{room: room, user: u}
+	state.timer = time.AfterFunc(typingTimeout, func() { t.expire(key, state) })
+	t.states[key] = state
+	t.mu.Unlock()
+
+	broadcastTypingState(room, u, true)
+}
+
+// Stop clears the typing state of the user in the room, if any.
+func (t *TypingTracker) Stop(room ChatRoom, u *User) {
+	key := typingKey(room.ID, u.ID)
+
+	t.mu.Lock()
+	state, exists := t.states[key]
+	if exists {
+		state.timer.Stop()
+		delete(t.states, key)
+	}
+	t.mu.Unlock()
+
+	if exists {
+		broadcastTypingState(room, u, false)
+	}
+}
+
+func (t *TypingTracker) expire(key string, state *typingState) {
+	t.mu.Lock()
+	// the state may have been stopped, or replaced, while the timer was firing
+	if current, exists := t.states[key]; !exists || current != state {
+		t.mu.Unlock()
+		return
+	}
+	delete(t.states, key)
+	t.mu.Unlock()
+
+	broadcastTypingState(state.room, state.user, false)
+}
+
+func broadcastTypingState(room ChatRoom, u *User, typing bool) {
+	currentUserId := u.ID.String()
+	usersIds := []string{}
+	for _, id := range room.UsersIDs {
+		if id != currentUserId {
+			usersIds = append(usersIds, id)
+		}
+	}
+	BroadcastWSMassage(usersIds, func(userId string) WSClientEventMessage {
+		return WSClientEventMessage{
+			Type: WSTypingEvent,
+			DataModel: TypingResource{
+				RoomID:   room.ID,
+				UserID:   u.ID,
+				UserName: u.Name,
+				Typing:   typing,
+			},
+		}
+	})
+}
+
+type SSETyping struct {
+	ChatRoomID string `json:"room_id"`
+}
+
+func handleTypingEvent(clientConn *WSClientSocket, event SSEType, data SSETyping) error {
+	if event == SSETypingStartEvent {
+		// throttle repeated typing events of the same connection, the state is
+		// already kept alive by the tracker for typingTimeout
+		if last, ok := clientConn.lastTypingAt[data.ChatRoomID]; ok && time.Since(last) < typingThrottle {
+			return nil
+		}
+		clientConn.lastTypingAt[data.ChatRoomID] = time.Now()
+	} else {
+		delete(clientConn.lastTypingAt, data.ChatRoomID)
+	}
+
+	currentUser := clientConn.user
+	room, err := getRoomForMember(DB(), data.ChatRoomID, currentUser)
+	if err != nil {
+		return err
+	}
+
+	if event == SSETypingStartEvent {
+		typingTracker.Start(*room, currentUser)
+	} else {
+		typingTracker.Stop(*room, currentUser)
+	}
+	return nil
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// The uploads implement the core tus protocol (https://tus.io/protocols/resumable-upload)
+// along with the creation, creation-with-upload, termination and expiration extensions.
+const (
+	TusVersion           = "1.0.0"
+	tusExtensions        = "creation,creation-with-upload,termination,expiration"
+	tusOffsetContentType = "application/offset+octet-stream"
+
+	uploadExpiration       = 24 * time.Hour // renewed by every received chunk
+	uploadsCleanupInterval = time.Hour
+)
+
+var (
+	UploadsDir             = GetenvDef("UPLOADS_DIR", "./uploads")
+	MaxResumableUploadSize = GetenvDef("MAX_RESUMABLE_UPLOAD_SIZE_MB", "2048")
+)
+
+func maxResumableUploadSize() int64 {
+	mb, err := strconv.ParseInt(MaxResumableUploadSize, 10, 64)
+	if err != nil || mb <= 0 {
+		mb = 2048
+	}
+	return mb * 1024 * 1024
+}
+
+func uploadFilePath(id UUID) string { return filepath.Join(UploadsDir, id.String()) }
+
+func uploadLocation(id UUID) string { return "/api/v1/uploads/" + id.String() }
+
+// parseUploadMetadata parses the Upload-Metadata header, a comma separated
+// list of `key base64(value)` pairs.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "invalid Upload-Metadata header")
+		}
+		out[key] = string(value)
+	}
+	return out, nil
+}
+
+func setUploadHeaders(c *fiber.Ctx, up *Upload) {
+	c.Set("Upload-Offset", strconv.FormatInt(up.Offset, 10))
+	c.Set("Upload-Expires", up.ExpiresAt.UTC().Format(http.TimeFormat))
+	if up.AttachmentID != nil {
+		c.Set("Attachment-Id", up.AttachmentID.String())
+	}
+}
+
+// TusOptions describes the capabilities of the server.
+func TusOptions(c *fiber.Ctx) error {
+	c.Set("Tus-Version", TusVersion)
+	c.Set("Tus-Extension", tusExtensions)
+	c.Set("Tus-Max-Size", strconv.FormatInt(maxResumableUploadSize(), 10))
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func CreateUpload(c *fiber.Ctx, u *User) error {
+	if c.Get("Upload-Defer-Length") != "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Upload-Defer-Length is not supported")
+	}
+	length, err := strconv.ParseInt(c.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid Upload-Length header")
+	}
+	if length > maxResumableUploadSize() {
+		return fiber.NewError(fiber.StatusRequestEntityTooLarge, "Upload-Length exceeds Tus-Max-Size")
+	}
+	metadata, err := parseUploadMetadata(c.Get("Upload-Metadata"))
+	if err != nil {
+		return err
+	}
+	fileName := metadata["filename"]
+	if fileName == "" {
+		fileName = metadata["name"]
+	}
+
+	up := &Upload{
+		ID:           NewUUIDv4(),
+		UploadedByID: u.ID,
+		FileName:     sanitizeFileName(fileName),
+		Length:       length,
+		Metadata:     c.Get("Upload-Metadata"),
+		ExpiresAt:    time.Now().Add(uploadExpiration),
+	}
+	if err := os.MkdirAll(UploadsDir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(uploadFilePath(up.ID))
+	if err != nil {
+		return err
+	}
+	f.Close()
+	if err := DB().Create(up).Error; err != nil {
+		os.Remove(uploadFilePath(up.ID))
+		return err
+	}
+
+	// creation-with-upload, the request may carry the first chunk
+	if len(c.Body()) > 0 && c.Get(fiber.HeaderContentType) == tusOffsetContentType {
+		if up, err = writeUploadChunk(u, up.ID.String(), 0, c.Body()); err != nil {
+			return err
+		}
+	}
+
+	c.Location(uploadLocation(up.ID))
+	setUploadHeaders(c, up)
+	return c.SendStatus(fiber.StatusCreated)
+}
+
+// getOwnUpload returns the upload only if it was created by the user and did not expire.
+func getOwnUpload(tx *gorm.DB, uploadID string, u *User) (*Upload, error) {
+	if _, err := UUIDFromString(uploadID); err != nil {
+		return nil, fiber.NewError(fiber.StatusNotFound, "upload not found")
+	}
+	up := &Upload{}
+	if err := tx.Where("id = ?", uploadID).
+		Where("uploaded_by_id = ?", u.ID).
+		First(up).Error; err != nil &&
+		!errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if up.ID.IsEmpty() {
+		return nil, fiber.NewError(fiber.StatusNotFound, "upload not found")
+	}
+	if up.ExpiresAt.Before(time.Now()) {
+		return nil, fiber.NewError(fiber.StatusGone, "upload expired")
+	}
+	return up, nil
+}
+
+func GetUploadOffset(c *fiber.Ctx, u *User, uploadID string) error {
+	up, err := getOwnUpload(DB(), uploadID, u)
+	if err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set("Upload-Length", strconv.FormatInt(up.Length, 10))
+	if up.Metadata != "" {
+		c.Set("Upload-Metadata", up.Metadata)
+	}
+	setUploadHeaders(c, up)
+	return c.SendStatus(fiber.StatusOK)
+}
+
+func PatchUpload(c *fiber.Ctx, u *User, uploadID string) error {
+	if c.Get(fiber.HeaderContentType) != tusOffsetContentType {
+		return fiber.NewError(fiber.StatusUnsupportedMediaType, "Content-Type must be "+tusOffsetContentType)
+	}
+	offset, err := strconv.ParseInt(c.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid Upload-Offset header")
+	}
+	up, err := writeUploadChunk(u, uploadID, offset, c.Body())
+	if err != nil {
+		return err
+	}
+	setUploadHeaders(c, up)
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// writeUploadChunk appends the chunk to the upload at the given offset, the
+// upload row is locked so concurrent requests can not interleave. Once the
+// last chunk is received the upload is turned into an attachment.
+func writeUploadChunk(u *User, uploadID string, offset int64, chunk []byte) (*Upload, error) {
+	var up *Upload
+	if err := DB().Transaction(func(tx *gorm.DB) error {
+		var err error
+		up, err = getOwnUpload(tx.Clauses(clause.Locking{Strength: "UPDATE"}), uploadID, u)
+		if err != nil {
+			return err
+		}
+		if up.IsComplete() {
+			// an empty chunk at the end retries a finalization that failed
+			if up.AttachmentID == nil && offset == up.Length && len(chunk) == 0 {
+				return nil
+			}
+			return fiber.NewError(fiber.StatusConflict, "upload is already complete")
+		}
+		if offset != up.Offset {
+			return fiber.NewError(fiber.StatusConflict, "Upload-Offset does not match the current offset of the upload")
+		}
+		if offset+int64(len(chunk)) > up.Length {
+			return fiber.NewError(fiber.StatusBadRequest, "chunk exceeds Upload-Length")
+		}
+
+		f, err := os.OpenFile(uploadFilePath(up.ID), os.O_WRONLY, 0o644)
+		if errors.Is(err, os.ErrNotExist) {
+			return fiber.NewError(fiber.StatusGone, "upload data is no longer available")
+		}
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		// drop the leftovers of a previously failed write
+		if err := f.Truncate(up.Offset); err != nil {
+			return err
+		}
+		if _, err := f.WriteAt(chunk, up.Offset); err != nil {
+			return err
+		}
+
+		up.Offset += int64(len(chunk))
+		up.ExpiresAt = time.Now().Add(uploadExpiration)
+		return tx.Model(&Upload{}).Where("id = ?", up.ID).Updates(map[string]interface{}{
+			"upload_offset": up.Offset,
+			"expires_at":    up.ExpiresAt,
+			"updated_at":    time.Now(),
+		}).Error
+	}); err != nil {
+		return nil, err
+	}
+	if !up.IsComplete() {
+		return up, nil
+	}
+	return finalizeUpload(up, u)
+}
+
+// finalizeUpload turns a complete upload into an attachment. The file is
+// copied to the storage without holding the lock of the upload, nor the
+// deadline of ctx() that a large video outlives, then the attachment is
+// linked in a short transaction.
+func finalizeUpload(up *Upload, u *User) (*Upload, error) {
+	f, err := os.Open(uploadFilePath(up.ID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fiber.NewError(fiber.StatusGone, "upload data is no longer available")
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	attachment, err := storeAttachment(&CreateAttachmentInput{
+		Ctx:      context.Background(),
+		U:        u,
+		FileName: up.FileName,
+		Reader:   io.LimitReader(f, up.Length),
+		Size:     up.Length,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	linked := false
+	if err := DB().Transaction(func(tx *gorm.DB) error {
+		locked := &Upload{}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", up.ID).
+			First(locked).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+			// terminated while the file was copied
+			return fiber.NewError(fiber.StatusGone, "upload data is no longer available")
+		} else if err != nil {
+			return err
+		}
+		// a concurrent retry linked its own attachment first
+		if locked.AttachmentID != nil {
+			up.AttachmentID = locked.AttachmentID
+			return nil
+		}
+		if err := tx.Create(attachment).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&Upload{}).Where("id = ?", up.ID).Updates(map[string]interface{}{
+			"attachment_id": attachment.ID,
+			"updated_at":    time.Now(),
+		}).Error; err != nil {
+			return err
+		}
+		up.AttachmentID = &attachment.ID
+		linked = true
+		return nil
+	}); err != nil {
+		Storage().Delete(ctx(), attachment.StorageKey)
+		return nil, err
+	}
+	if !linked {
+		Storage().Delete(ctx(), attachment.StorageKey)
+	} else if attachment.NeedsImageProcessing() {
+		enqueueImageProcessing(attachment.ID)
+	}
+	os.Remove(uploadFilePath(up.ID))
+	return up, nil
+}
+
+// TerminateUpload deletes the upload and its data, the attachment of a
+// complete upload is kept.
+func TerminateUpload(c *fiber.Ctx, u *User, uploadID string) error {
+	up, err := getOwnUpload(DB(), uploadID, u)
+	if err != nil {
+		return err
+	}
+	if err := DB().Delete(&Upload{}, "id = ?", up.ID).Error; err != nil {
+		return err
+	}
+	os.Remove(uploadFilePath(up.ID))
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// uploadsCleanupLoop deletes the expired uploads along with their data, until
+// stop is closed.
+func uploadsCleanupLoop(stop <-chan struct{}) {
+	ticker := time.NewTicker(uploadsCleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if err := cleanupExpiredUploads(); err != nil {
+			AppLogger.WithError(err).Error("failed to cleanup expired uploads")
+		}
+	}
+}
+
+func cleanupExpiredUploads() error {
+	expired := []Upload{}
+	if err := DB().Select("id").
+		Where("expires_at < ?", time.Now()).
+		Find(&expired).Error; err != nil {
+		return err
+	}
+	for _, up := range expired {
+		if err := DB().Delete(&Upload{}, "id = ?", up.ID).Error; err != nil {
+			return err
+		}
+		os.Remove(uploadFilePath(up.ID))
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type MessagePlayedResource struct {
+	MessageID uint      `json:"message_id"`
+	RoomID    UUID      `json:"room_id"`
+	UserID    UUID      `json:"user_id"`
+	PlayedAt  time.Time `json:"played_at"`
+}
+
+// MarkVoiceNotePlayed records that the user played the voice note, the first
+// play is kept. Once played, a play once voice note can not be downloaded
+// again by the user.
+func MarkVoiceNotePlayed(u *User, messageID uint) (*MessagePlayedResource, error) {
+	tx := DB()
+	msg, room, err := getMessageForMember(tx, messageID, u)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Type != CMTypeAudio {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "only voice notes can be played")
+	}
+	if msg.CreatedByID == u.ID {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "you can not mark your own voice note as played")
+	}
+
+	return recordVoiceNotePlay(tx, msg, room, u)
+}
+
+// recordVoiceNotePlay stores the play of the user, keeping the first one, and
+// broadcasts it to the members of the room.
+func recordVoiceNotePlay(tx *gorm.DB, msg *ChatMessage, room *ChatRoom, u *User) (*MessagePlayedResource, error) {
+	play := &ChatMessagePlay{ChatMessageID: msg.ID, UserID: u.ID, PlayedAt: time.Now()}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(play).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("chat_message_id = ?", msg.ID).
+		Where("user_id = ?", u.ID).
+		First(play).Error; err != nil {
+		return nil, err
+	}
+
+	out := &MessagePlayedResource{
+		MessageID: msg.ID,
+		RoomID:    room.ID,
+		UserID:    u.ID,
+		PlayedAt:  play.PlayedAt,
+	}
+	BroadcastWSMassage(room.UsersIDs, func(userId string) WSClientEventMessage {
+		return WSClientEventMessage{
+			Type:      WSMessagePlayedEvent,
+			DataModel: *out,
+		}
+	})
+	return out, nil
+}
+
+// recordPlayOnceDownload records the download of the attachment as a play of
+// the play once voice notes carrying it that the user did not play yet, the
+// clients can not be trusted to mark them as played. played is false if the
+// attachment is not such a voice note.
+func recordPlayOnceDownload(u *User, attachmentID UUID) (played bool, err error) {
+	tx := DB()
+	messages := []ChatMessage{}
+	if err := tx.Joins(`JOIN "chat_rooms" ON "chat_rooms"."id" = "chat_messages"."chat_room_id"`).
+		Where("chat_messages.attachment_id = ?", attachmentID).
+		Where("chat_messages.play_once = TRUE").
+		Where("chat_messages.created_by_id <> ?", u.ID).
+		Where("? = ANY(chat_rooms.users_ids)", u.ID).
+		Where("chat_rooms.deleted_at IS NULL").
+		Where(`NOT EXISTS (SELECT 1 FROM "chat_message_plays" WHERE "chat_message_plays"."chat_message_id" = "chat_messages"."id" AND "chat_message_plays"."user_id" = ?)`, u.ID).
+		Find(&messages).Error; err != nil {
+		return false, err
+	}
+	for i := range messages {
+		room, err := getRoomForMember(tx, messages[i].ChatRoomID.String(), u)
+		if err != nil {
+			return false, err
+		}
+		if _, err := recordVoiceNotePlay(tx, &messages[i], room, u); err != nil {
+			return false, err
+		}
+	}
+	return len(messages) > 0, nil
+}
+
+// loadMessagesPlays returns the plays of the messages indexed by message id.
+func loadMessagesPlays(messagesIDs []uint) (map[uint][]ChatMessagePlay, error) {
+	out := map[uint][]ChatMessagePlay{}
+	if len(messagesIDs) == 0 {
+		return out, nil
+	}
+	plays := []ChatMessagePlay{}
+	if err := DB().Where("chat_message_id IN ?", messagesIDs).
+		Order("played_at ASC").
+		Find(&plays).Error; err != nil {
+		return nil, err
+	}
+	for _, v := range plays {
+		out[v.ChatMessageID] = append(out[v.ChatMessageID], v)
+	}
+	return out, nil
+}
+
+func playedByUsersIDs(plays []ChatMessagePlay) []UUID {
+	out := []UUID{}
+	for _, v := range plays {
+		out = append(out, v.UserID)
+	}
+	return out
+}
+
+// hasPlayedOnce reports whether the user already played a play once voice
+// note carrying the attachment.
+func hasPlayedOnce(u *User, attachmentID string) (bool, error) {
+	var count int64
+	if err := DB().Model(&ChatMessage{}).
+		Joins(`JOIN "chat_message_plays" ON "chat_message_plays"."chat_message_id" = "chat_messages"."id"`).
+		Where("chat_messages.attachment_id = ?", attachmentID).
+		Where("chat_messages.play_once = TRUE").
+		Where("chat_message_plays.user_id = ?", u.ID).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
@@ -132,6 +132,10 @@ func (a *StringArray) Scan(value interface{}) (err error) {
 		return errors.New("failed to scan multi-string field - source is not a string")
 	}
 	str = strings.Trim(str, "{}")
+	if str == "" {
+		*a = StringArray{}
+		return nil
+	}
 	*a = strings.Split(str, ",")
 	return nil
 }
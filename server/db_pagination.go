@@ -12,17 +12,33 @@ import (
 type PaginationActionFn func(tx *gorm.DB) *gorm.DB
 
 type PaginatedData[T any] struct {
-	Data       []T  `json:"data"`
-	Total      int  `json:"total"`
-	Page       int  `json:"page"`
-	PerPage    int  `json:"per_page"`
-	Prev       *int `json:"prev"`
-	Next       *int `json:"next"`
-	PagesCount int  `json:"pages_count"`
-	Limit      int  `json:"limit"`
+	Data       []T     `json:"data"`
+	Total      int     `json:"total"` // 0 when the request opted out of the count with count=false
+	Page       int     `json:"page"`
+	PerPage    int     `json:"per_page"`
+	Prev       *int    `json:"prev"`
+	Next       *int    `json:"next"`
+	PagesCount int     `json:"pages_count"`
+	Limit      int     `json:"limit"`
+	PrevCursor *string `json:"prev_cursor"` // only in the cursor mode
+	NextCursor *string `json:"next_cursor"` // only in the cursor mode
 }
 
+// Paginate pages the rows with LIMIT/OFFSET, from the page or offset query
+// parameters. The total is counted unless the request has count=false.
 func Paginate[T schema.Tabler](c *fiber.Ctx, model T, countStmt *gorm.DB, fns ...PaginationActionFn) (*PaginatedData[T], error) {
+	return paginate(c, model, countStmt, nil, fns)
+}
+
+// PaginateWithCursor is Paginate with a cursor mode, used when the request has
+// a cursor query parameter, empty for the first page. The rows are ordered by
+// the key and the pages are fetched from the next_cursor/prev_cursor of the
+// previous response, so rows inserted between two requests don't shift them.
+func PaginateWithCursor[T schema.Tabler](c *fiber.Ctx, model T, countStmt *gorm.DB, key CursorKey[T], fns ...PaginationActionFn) (*PaginatedData[T], error) {
+	return paginate(c, model, countStmt, &key, fns)
+}
+
+func paginate[T schema.Tabler](c *fiber.Ctx, model T, countStmt *gorm.DB, key *CursorKey[T], fns []PaginationActionFn) (*PaginatedData[T], error) {
 	limitVal, pageVal, offsetVal := c.Query("limit"), c.Query("page"), c.Query("offset")
 	var limit, page, offset int
 	if limitVal == "" {
@@ -30,6 +46,15 @@ func Paginate[T schema.Tabler](c *fiber.Ctx, model T, countStmt *gorm.DB, fns ..
 	} else {
 		limit, _ = strconv.Atoi(limitVal)
 	}
+	if limit <= 0 {
+		limit = 10
+	}
+	withCount := c.QueryBool("count", true)
+
+	if key != nil && c.Context().QueryArgs().Has("cursor") {
+		return paginateByCursor(c.Query("cursor"), limit, withCount, model, countStmt, key, fns)
+	}
+
 	// page or offset is required but not both
 	// if neither is set then use the default values
 	// if page is set then calculate the offset
@@ -45,29 +70,10 @@ func Paginate[T schema.Tabler](c *fiber.Ctx, model T, countStmt *gorm.DB, fns ..
 		offset = 0
 	}
 
-	var total int64
-	{
-		selects := countStmt.Statement.Selects
-		tx := countStmt.Session(&gorm.Session{}).Unscoped()
-		if len(selects) > 0 {
-			tx = tx.Select("COUNT(*)")
-		}
-		if err := tx.Table(model.TableName()).Count(&total).Error; err != nil {
-			return nil, err
-		}
-	}
-
-	pagesCount := int(total) / limit
-	if int(total)%limit != 0 {
-		pagesCount++
-	}
-
 	pd := PaginatedData[T]{
-		Total:      int(total),
-		Page:       page,
-		PerPage:    limit,
-		PagesCount: pagesCount,
-		Limit:      limit,
+		Page:    page,
+		PerPage: limit,
+		Limit:   limit,
 	}
 
 	if page > 1 {
@@ -75,37 +81,70 @@ func Paginate[T schema.Tabler](c *fiber.Ctx, model T, countStmt *gorm.DB, fns ..
 		pd.Prev = &prev
 	}
 
-	if page < pagesCount {
-		next := page + 1
-		pd.Next = &next
-	}
+	if withCount {
+		total, err := countRows(model, countStmt)
+		if err != nil {
+			return nil, err
+		}
 
-	// if the page is greater than the total pages, return an empty array
-	if (page-1)*limit > int(total) {
-		pd.Data = []T{}
-		return &pd, nil
+		pagesCount := int(total) / limit
+		if int(total)%limit != 0 {
+			pagesCount++
+		}
+		pd.Total = int(total)
+		pd.PagesCount = pagesCount
+
+		if page < pagesCount {
+			next := page + 1
+			pd.Next = &next
+		}
+
+		// if the page is greater than the total pages, return an empty array
+		if (page-1)*limit > int(total) {
+			pd.Data = []T{}
+			return &pd, nil
+		}
 	}
 
 	stmt := countStmt.Table(model.TableName()).Session(&gorm.Session{}).Unscoped().Model(model)
-	if len(fns) > 0 {
-		for _, fn := range fns {
-			if fn != nil {
-				stmt = fn(stmt)
-			}
+	for _, fn := range fns {
+		if fn != nil {
+			stmt = fn(stmt)
 		}
-		stmt = stmt.Limit(limit).Offset(offset)
-	} else {
-		stmt = stmt.Limit(limit).Offset(offset)
 	}
+	// without the count, one more row tells whether there is a next page
+	fetchLimit := limit
+	if !withCount {
+		fetchLimit++
+	}
+	stmt = stmt.Limit(fetchLimit).Offset(offset)
 
 	data := []T{}
 	if err := stmt.Find(&data).Error; err != nil {
 		return nil, err
 	}
+	if !withCount && len(data) > limit {
+		data = data[:limit]
+		next := page + 1
+		pd.Next = &next
+	}
 	pd.Data = data
 	return &pd, nil
 }
 
+func countRows[T schema.Tabler](model T, countStmt *gorm.DB) (int64, error) {
+	var total int64
+	selects := countStmt.Statement.Selects
+	tx := countStmt.Session(&gorm.Session{}).Unscoped()
+	if len(selects) > 0 {
+		tx = tx.Select("COUNT(*)")
+	}
+	if err := tx.Table(model.TableName()).Count(&total).Error; err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
 type PDTransformer[T, A any] func(data T) (A, error)
 
 func TransformPaginatedData[T, A any](src *PaginatedData[T], transformer PDTransformer[T, A]) (*PaginatedData[A], error) {
@@ -124,6 +163,8 @@ func TransformPaginatedData[T, A any](src *PaginatedData[T], transformer PDTrans
 		Next:       src.Next,
 		PagesCount: src.PagesCount,
 		Limit:      src.Limit,
+		PrevCursor: src.PrevCursor,
+		NextCursor: src.NextCursor,
 	}
 	for _, data := range src.Data {
 		a, err := transformer(data)
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// CursorKey is the sort key of the cursor pagination. The columns must be
+// unique together, ending with the primary key is the usual way, so that a
+// cursor points between two rows.
+type CursorKey[T any] struct {
+	Columns []string          // qualified column names, in their ORDER BY order
+	Desc    bool              // descending order, for the newest first lists
+	Values  func(row T) []any // the values of the columns in a row
+}
+
+// messagesCursorKey pages the messages newest first.
+var messagesCursorKey = CursorKey[ChatMessage]{
+	Columns: []string{"chat_messages.created_at", "chat_messages.id"},
+	Desc:    true,
+	Values:  func(row ChatMessage) []any { return []any{row.CreatedAt, row.ID} },
+}
+
+// repliesCursorKey pages the replies of a thread oldest first.
+var repliesCursorKey = CursorKey[ChatMessage]{
+	Columns: messagesCursorKey.Columns,
+	Values:  messagesCursorKey.Values,
+}
+
+type cursorDirection string
+
+const (
+	cursorNext cursorDirection = "next" // the rows after the key, in the key order
+	cursorPrev cursorDirection = "prev" // the rows before the key
+)
+
+type cursorPayload struct {
+	Direction cursorDirection   `json:"d"`
+	Values    []json.RawMessage `json:"k"`
+}
+
+// encode returns the opaque cursor of the position right after, or before,
+// the row. The clients must not rely on its content.
+func (k *CursorKey[T]) encode(direction cursorDirection, row T) (*string, error) {
+	values := []json.RawMessage{}
+	for _, v := range k.Values(row) {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, b)
+	}
+	b, err := json.Marshal(cursorPayload{Direction: direction, Values: values})
+	if err != nil {
+		return nil, err
+	}
+	out := base64.RawURLEncoding.EncodeToString(b)
+	return &out, nil
+}
+
+// decode parses a cursor back into typed values, the types are the ones
+// Values returns so that they are compared to the columns as such.
+func (k *CursorKey[T]) decode(cursor string) (cursorDirection, []any, error) {
+	invalid := fiber.NewError(fiber.StatusBadRequest, "invalid cursor")
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", nil, invalid
+	}
+	payload := cursorPayload{}
+	if err := json.Unmarshal(b, &payload); err != nil {
+		return "", nil, invalid
+	}
+	if payload.Direction != cursorNext && payload.Direction != cursorPrev {
+		return "", nil, invalid
+	}
+	var zero T
+	types := k.Values(zero)
+	if len(payload.Values) != len(types) {
+		return "", nil, invalid
+	}
+	values := []any{}
+	for i, raw := range payload.Values {
+		v := reflect.New(reflect.TypeOf(types[i]))
+		if err := json.Unmarshal(raw, v.Interface()); err != nil {
+			return "", nil, invalid
+		}
+		values = append(values, v.Elem().Interface())
+	}
+	return payload.Direction, values, nil
+}
+
+// orderBy returns the ORDER BY of the key, reversed for the previous pages.
+func (k *CursorKey[T]) orderBy(reversed bool) string {
+	order := "ASC"
+	if k.Desc != reversed {
+		order = "DESC"
+	}
+	columns := []string{}
+	for _, c := range k.Columns {
+		columns = append(columns, c+" "+order)
+	}
+	return strings.Join(columns, ", ")
+}
+
+// after returns the condition of the rows past the values, in the order of
+// the key, or before them when reversed.
+func (k *CursorKey[T]) after(values []any, reversed bool) (string, []any) {
+	cmp := ">"
+	if k.Desc != reversed {
+		cmp = "<"
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ")
+	return "(" + strings.Join(k.Columns, ", ") + ") " + cmp + " (" + placeholders + ")", values
+}
+
+func paginateByCursor[T schema.Tabler](cursor string, limit int, withCount bool, model T, countStmt *gorm.DB, key *CursorKey[T], fns []PaginationActionFn) (*PaginatedData[T], error) {
+	direction := cursorNext
+	var values []any
+	if cursor != "" {
+		var err error
+		if direction, values, err = key.decode(cursor); err != nil {
+			return nil, err
+		}
+	}
+	reversed := direction == cursorPrev
+
+	pd := PaginatedData[T]{
+		PerPage: limit,
+		Limit:   limit,
+	}
+	if withCount {
+		total, err := countRows(model, countStmt)
+		if err != nil {
+			return nil, err
+		}
+		pd.Total = int(total)
+		pd.PagesCount = pd.Total / limit
+		if pd.Total%limit != 0 {
+			pd.PagesCount++
+		}
+	}
+
+	// the key order goes first, the order of the fns only breaks ties that a
+	// unique key never has
+	stmt := countStmt.Table(model.TableName()).Session(&gorm.Session{}).Unscoped().Model(model)
+	if values != nil {
+		cond, vars := key.after(values, reversed)
+		stmt = stmt.Where(cond, vars...)
+	}
+	stmt = stmt.Order(key.orderBy(reversed))
+	for _, fn := range fns {
+		if fn != nil {
+			stmt = fn(stmt)
+		}
+	}
+
+	data := []T{}
+	if err := stmt.Limit(limit + 1).Find(&data).Error; err != nil {
+		return nil, err
+	}
+	data, hasNext, hasPrev := cursorPage(data, limit, reversed, values != nil)
+	pd.Data = data
+
+	var err error
+	if hasNext {
+		if pd.NextCursor, err = key.encode(cursorNext, data[len(data)-1]); err != nil {
+			return nil, err
+		}
+	}
+	if hasPrev {
+		if pd.PrevCursor, err = key.encode(cursorPrev, data[0]); err != nil {
+			return nil, err
+		}
+	}
+	return &pd, nil
+}
+
+// cursorPage trims the extra row fetched to know whether more rows follow,
+// puts the rows of a previous page back in the key order, and tells which
+// cursors the page has. There are rows on the side the page was reached from,
+// and on the other side only if the extra row was fetched.
+func cursorPage[T any](rows []T, limit int, reversed, fromCursor bool) (data []T, hasNext, hasPrev bool) {
+	hasMore := len(rows) > limit
+	if hasMore {
+		rows = rows[:limit]
+	}
+	if reversed {
+		slices.Reverse(rows)
+	}
+	if len(rows) == 0 {
+		return rows, false, false
+	}
+	hasNext = hasMore || reversed
+	hasPrev = (hasMore && reversed) || (!reversed && fromCursor)
+	return rows, hasNext, hasPrev
+}
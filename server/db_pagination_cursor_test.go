@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/base64"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCursorKeyRoundTrip(t *testing.T) {
+	createdAt := time.Date(2024, 12, 21, 10, 30, 15, 123456789, time.UTC)
+	tests := []struct {
+		name      string
+		direction cursorDirection
+		row       ChatMessage
+	}{
+		{"next", cursorNext, ChatMessage{ID: 42, CreatedAt: createdAt}},
+		{"prev", cursorPrev, ChatMessage{ID: 1, CreatedAt: createdAt}},
+		{"zero row", cursorNext, ChatMessage{}},
+		{"other time zone", cursorNext, ChatMessage{ID: 7, CreatedAt: createdAt.In(time.FixedZone("UTC+2", 2*60*60))}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor, err := messagesCursorKey.encode(tt.direction, tt.row)
+			if err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+			direction, values, err := messagesCursorKey.decode(*cursor)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if direction != tt.direction {
+				t.Errorf("direction = %q, want %q", direction, tt.direction)
+			}
+			if len(values) != 2 {
+				t.Fatalf("got %d values, want 2", len(values))
+			}
+			if at, ok := values[0].(time.Time); !ok || !at.Equal(tt.row.CreatedAt) {
+				t.Errorf("created_at = %v, want %v", values[0], tt.row.CreatedAt)
+			}
+			if id, ok := values[1].(uint); !ok || id != tt.row.ID {
+				t.Errorf("id = %v, want %d", values[1], tt.row.ID)
+			}
+		})
+	}
+}
+
+func TestCursorKeyDecodeInvalid(t *testing.T) {
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"not base64", "not a cursor!"},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte(`{"d":"next","k":["2024-12-21T10:30:15Z",1]}`))},
+		{"not json", encode("next:1")},
+		{"unknown direction", encode(`{"d":"up","k":["2024-12-21T10:30:15Z",1]}`)},
+		{"missing direction", encode(`{"k":["2024-12-21T10:30:15Z",1]}`)},
+		{"missing values", encode(`{"d":"next"}`)},
+		{"too few values", encode(`{"d":"next","k":[1]}`)},
+		{"too many values", encode(`{"d":"next","k":["2024-12-21T10:30:15Z",1,2]}`)},
+		{"invalid time", encode(`{"d":"next","k":["yesterday",1]}`)},
+		{"string id", encode(`{"d":"next","k":["2024-12-21T10:30:15Z","1"]}`)},
+		{"negative id", encode(`{"d":"next","k":["2024-12-21T10:30:15Z",-1]}`)},
+		{"sql in id", encode(`{"d":"next","k":["2024-12-21T10:30:15Z","1) OR (1=1"]}`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := messagesCursorKey.decode(tt.cursor); err == nil {
+				t.Errorf("decode(%q) succeeded, want an error", tt.cursor)
+			}
+		})
+	}
+}
+
+func TestCursorKeyOrder(t *testing.T) {
+	tests := []struct {
+		name      string
+		key       CursorKey[ChatMessage]
+		reversed  bool
+		wantOrder string
+		wantCond  string
+	}{
+		{"newest first", messagesCursorKey, false,
+			"chat_messages.created_at DESC, chat_messages.id DESC",
+			"(chat_messages.created_at, chat_messages.id) < (?, ?)"},
+		{"newest first, previous page", messagesCursorKey, true,
+			"chat_messages.created_at ASC, chat_messages.id ASC",
+			"(chat_messages.created_at, chat_messages.id) > (?, ?)"},
+		{"oldest first", repliesCursorKey, false,
+			"chat_messages.created_at ASC, chat_messages.id ASC",
+			"(chat_messages.created_at, chat_messages.id) > (?, ?)"},
+		{"oldest first, previous page", repliesCursorKey, true,
+			"chat_messages.created_at DESC, chat_messages.id DESC",
+			"(chat_messages.created_at, chat_messages.id) < (?, ?)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.key.orderBy(tt.reversed); got != tt.wantOrder {
+				t.Errorf("orderBy = %q, want %q", got, tt.wantOrder)
+			}
+			values := []any{time.Now(), uint(1)}
+			cond, vars := tt.key.after(values, tt.reversed)
+			if cond != tt.wantCond {
+				t.Errorf("after = %q, want %q", cond, tt.wantCond)
+			}
+			if len(vars) != len(values) {
+				t.Errorf("after returned %d vars, want %d", len(vars), len(values))
+			}
+		})
+	}
+}
+
+func TestCursorPage(t *testing.T) {
+	const limit = 3
+	tests := []struct {
+		name       string
+		rows       []int // as fetched, in the order of the query
+		reversed   bool
+		fromCursor bool
+		wantData   []int
+		wantNext   bool
+		wantPrev   bool
+	}{
+		{"empty first page", nil, false, false, []int{}, false, false},
+		{"single first page", []int{1, 2}, false, false, []int{1, 2}, false, false},
+		{"full single first page", []int{1, 2, 3}, false, false, []int{1, 2, 3}, false, false},
+		{"first page with more", []int{1, 2, 3, 4}, false, false, []int{1, 2, 3}, true, false},
+		{"middle next page", []int{4, 5, 6, 7}, false, true, []int{4, 5, 6}, true, true},
+		{"last next page", []int{7, 8}, false, true, []int{7, 8}, false, true},
+		{"empty next page", nil, false, true, []int{}, false, false},
+		{"middle prev page", []int{6, 5, 4, 3}, true, true, []int{4, 5, 6}, true, true},
+		{"first prev page", []int{3, 2, 1}, true, true, []int{1, 2, 3}, true, false},
+		{"empty prev page", nil, true, true, []int{}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows := append([]int{}, tt.rows...)
+			data, hasNext, hasPrev := cursorPage(rows, limit, tt.reversed, tt.fromCursor)
+			if !slices.Equal(data, tt.wantData) {
+				t.Errorf("data = %v, want %v", data, tt.wantData)
+			}
+			if hasNext != tt.wantNext {
+				t.Errorf("hasNext = %v, want %v", hasNext, tt.wantNext)
+			}
+			if hasPrev != tt.wantPrev {
+				t.Errorf("hasPrev = %v, want %v", hasPrev, tt.wantPrev)
+			}
+		})
+	}
+}
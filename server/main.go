@@ -4,6 +4,8 @@ import (
 	"errors"
 	"os"
 	"os/signal"
+	"strconv"
+	"sync"
 	"syscall"
 
 	"github.com/gofiber/fiber/v2"
@@ -17,8 +19,36 @@ import (
 
 var (
 	Port = GetenvDef("PORT", "3000")
+
+	MaxUploadSize = GetenvDef("MAX_UPLOAD_SIZE_MB", "50")
 )
 
+var (
+	stopC           = make(chan struct{}) // closed on shutdown, stops the background loops
+	backgroundLoops sync.WaitGroup
+)
+
+// goBackground runs a background loop, the shutdown waits for it to return
+// before closing the database.
+func goBackground(loop func(stop <-chan struct{})) {
+	backgroundLoops.Add(1)
+	go func() {
+		defer backgroundLoops.Done()
+		loop(stopC)
+	}()
+}
+
+// isStopped reports whether the shutdown started, the loops check it between
+// two batches.
+func isStopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
 func main() {
 	// handle signals, graceful shutdown
 	signals := make(chan os.Signal, 1)
@@ -31,6 +61,11 @@ func main() {
 	app := fiber.New(fiber.Config{
 		AppName:      "Spock",
 		ServerHeader: "Spock",
+		// the bodies larger than the default limit are streamed instead of
+		// buffered, BodyLimitMiddleware bounds them per route
+		BodyLimit:                    fiber.DefaultBodyLimit,
+		StreamRequestBody:            true,
+		DisablePreParseMultipartForm: true,
 		ErrorHandler: func(c *fiber.Ctx, err error) error {
 			if e, ok := err.(*MultipleErrorsErr); ok {
 				return c.Status(e.StatusCode).JSON(fiber.Map{
@@ -52,10 +87,17 @@ func main() {
 	})
 
 	app.Get("/metrics", monitor.New(monitor.Config{Title: "Spock Metrics Page"}))
-	app.Use(etag.New(), cors.New(), requestid.New(), compress.New(), logger.New())
+	app.Use(etag.New(), cors.New(), requestid.New(), compress.New(), logger.New(), BodyLimitMiddleware())
 
 	SetupRouters(app)
 
+	go wsClientsPool.redeliveryLoop()
+	go wsClientsPool.replayLogEvictionLoop()
+	goBackground(uploadsCleanupLoop)
+	goBackground(scheduledMessagesLoop)
+	goBackground(expiredMessagesLoop)
+	StartImageProcessing()
+
 	go func() {
 		err := app.Listen(":" + Port)
 		if err != nil {
@@ -73,6 +115,11 @@ func main() {
 		AppLogger.WithError(err).Error("failed to shutdown server")
 	}
 
+	// the loops finish their current batch, including its broadcasts, before
+	// the pool and the database are closed
+	close(stopC)
+	backgroundLoops.Wait()
+
 	wsClientsPool.close()
 
 	AppLogger.Info("server stopped")
@@ -85,4 +132,17 @@ func setupServer() {
 	if err := OpenDB(); err != nil {
 		panic(err)
 	}
+	if err := OpenStorage(); err != nil {
+		panic(err)
+	}
+}
+
+// maxUploadBodyLimit is the body limit in bytes of the upload routes, it
+// bounds the size of the uploads.
+func maxUploadBodyLimit() int {
+	mb, err := strconv.Atoi(MaxUploadSize)
+	if err != nil || mb <= 0 {
+		return fiber.DefaultBodyLimit
+	}
+	return mb * 1024 * 1024
 }
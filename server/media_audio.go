@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+const (
+	waveformLength       = 64               // values in a voice note waveform
+	maxAnalyzedAudioSize = 25 * 1024 * 1024 // larger audio files are stored without metadata
+)
+
+type audioInfo struct {
+	durationMs int64
+	waveform   []byte // levels from 0 to 255, relative to the loudest part, see waveformSources
+}
+
+var audioAnalyzers = map[string]func(data []byte) (*audioInfo, error){
+	"audio/ogg": analyzeOgg,
+	"audio/wav": analyzeWav,
+}
+
+const (
+	waveformFromAmplitude  = "amplitude"   // peak amplitudes of the decoded samples
+	waveformFromPacketSize = "packet_size" // sizes of the encoded packets, an approximation of the loudness
+)
+
+// waveformSources tells the clients what the waveform of a voice note is
+// computed from, only the amplitudes are an actual waveform.
+var waveformSources = map[string]string{
+	"audio/ogg": waveformFromPacketSize,
+	"audio/wav": waveformFromAmplitude,
+}
+
+func isAnalyzableAudio(mimeType string) bool { return audioAnalyzers[mimeType] != nil }
+
+// analyzeAudio computes the duration and the waveform of a voice note.
+func analyzeAudio(data []byte, mimeType string) (*audioInfo, error) {
+	analyzer := audioAnalyzers[mimeType]
+	if analyzer == nil {
+		return nil, errors.New("unsupported audio type: " + mimeType)
+	}
+	return analyzer(data)
+}
+
+// normalizeWaveform scales the levels so that the loudest one is 255.
+func normalizeWaveform(levels []float64) []byte {
+	peak := 0.0
+	for _, v := range levels {
+		peak = math.Max(peak, v)
+	}
+	out := make([]byte, len(levels))
+	if peak == 0 {
+		return out
+	}
+	for i, v := range levels {
+		out[i] = byte(math.Round(v / peak * 255))
+	}
+	return out
+}
+
+// analyzeWav reads PCM (integer or float) WAV files, the waveform is the peak
+// amplitude of every slice of the audio.
+func analyzeWav(data []byte) (*audioInfo, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, errors.New("invalid wav")
+	}
+	var format, channels, bitsPerSample uint16
+	var sampleRate uint32
+	var samples []byte
+	for i := 12; i+8 <= len(data); {
+		size := int(binary.LittleEndian.Uint32(data[i+4 : i+8]))
+		end := i + 8 + size
+		if end > len(data) || end < i {
+			// the size of the data chunk is often wrong in streamed recordings
+			end = len(data)
+		}
+		chunk := data[i+8 : end]
+		switch string(data[i : i+4]) {
+		case "fmt ":
+			if len(chunk) < 16 {
+				return nil, errors.New("invalid wav format chunk")
+			}
+			format = binary.LittleEndian.Uint16(chunk[0:2])
+			channels = binary.LittleEndian.Uint16(chunk[2:4])
+			sampleRate = binary.LittleEndian.Uint32(chunk[4:8])
+			bitsPerSample = binary.LittleEndian.Uint16(chunk[14:16])
+			if format == 0xFFFE && len(chunk) >= 26 { // WAVE_FORMAT_EXTENSIBLE
+				format = binary.LittleEndian.Uint16(chunk[24:26])
+			}
+		case "data":
+			samples = chunk
+		}
+		i = end + size%2
+	}
+	if channels == 0 || sampleRate == 0 || samples == nil {
+		return nil, errors.New("invalid wav, missing format or data chunk")
+	}
+
+	bytesPerSample := int(bitsPerSample) / 8
+	var readSample func(b []byte) float64 // in [-1, 1]
+	switch {
+	case format == 1 && bitsPerSample == 8:
+		readSample = func(b []byte) float64 { return (float64(b[0]) - 128) / 128 }
+	case format == 1 && bitsPerSample == 16:
+		readSample = func(b []byte) float64 { return float64(int16(binary.LittleEndian.Uint16(b))) / (1 << 15) }
+	case format == 1 && bitsPerSample == 24:
+		readSample = func(b []byte) float64 {
+			return float64(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24)>>8) / (1 << 23)
+		}
+	case format == 1 && bitsPerSample == 32:
+		readSample = func(b []byte) float64 { return float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31) }
+	case format == 3 && bitsPerSample == 32:
+		readSample = func(b []byte) float64 { return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))) }
+	default:
+		return nil, errors.New("unsupported wav encoding")
+	}
+
+	frameSize := bytesPerSample * int(channels)
+	frames := len(samples) / frameSize
+	out := &audioInfo{durationMs: int64(frames) * 1000 / int64(sampleRate)}
+	buckets := min(waveformLength, frames)
+	if buckets == 0 {
+		return out, nil
+	}
+	levels := make([]float64, buckets)
+	for frame := 0; frame < frames; frame++ {
+		bucket := frame * buckets / frames
+		for ch := 0; ch < int(channels); ch++ {
+			offset := frame*frameSize + ch*bytesPerSample
+			levels[bucket] = math.Max(levels[bucket], math.Abs(readSample(samples[offset:offset+bytesPerSample])))
+		}
+	}
+	out.waveform = normalizeWaveform(levels)
+	return out, nil
+}
+
+type oggPacket struct {
+	data    []byte
+	granule int64 // granule position of the page the packet ends in
+}
+
+// readOggPackets returns the packets of the first logical stream of the file.
+func readOggPackets(data []byte) ([]oggPacket, error) {
+	packets := []oggPacket{}
+	var serial uint32
+	partial := []byte{}
+	for i, first := 0, true; i < len(data); first = false {
+		if i+27 > len(data) || string(data[i:i+4]) != "OggS" {
+			return nil, errors.New("invalid ogg page")
+		}
+		granule := int64(binary.LittleEndian.Uint64(data[i+6 : i+14]))
+		pageSerial := binary.LittleEndian.Uint32(data[i+14 : i+18])
+		segmentsCount := int(data[i+26])
+		if i+27+segmentsCount > len(data) {
+			return nil, errors.New("invalid ogg page")
+		}
+		segments := data[i+27 : i+27+segmentsCount]
+		body := i + 27 + segmentsCount
+		if first {
+			serial = pageSerial
+		}
+		for _, size := range segments {
+			if body+int(size) > len(data) {
+				return nil, errors.New("truncated ogg page")
+			}
+			if pageSerial == serial {
+				partial = append(partial, data[body:body+int(size)]...)
+				if size < 255 {
+					packets = append(packets, oggPacket{data: partial, granule: granule})
+					partial = []byte{}
+				}
+			}
+			body += int(size)
+		}
+		i = body
+	}
+	return packets, nil
+}
+
+// analyzeOgg reads Opus or Vorbis files. The duration comes from the granule
+// position of the last page. The audio is not decoded, there is no codec, so
+// the waveform is not made of amplitudes but of packet sizes, see
+// oggPacketSizeLevels.
+func analyzeOgg(data []byte) (*audioInfo, error) {
+	packets, err := readOggPackets(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(packets) < 2 {
+		return nil, errors.New("invalid ogg, missing headers")
+	}
+
+	var rate, preSkip int64
+	var headers int
+	head := packets[0].data
+	switch {
+	case bytes.HasPrefix(head, []byte("OpusHead")) && len(head) >= 12:
+		rate = 48000 // opus granule positions are always at 48kHz
+		preSkip = int64(binary.LittleEndian.Uint16(head[10:12]))
+		headers = 2 // OpusHead, OpusTags
+	case bytes.HasPrefix(head, []byte("\x01vorbis")) && len(head) >= 16:
+		rate = int64(binary.LittleEndian.Uint32(head[12:16]))
+		headers = 3 // identification, comment, setup
+	default:
+		return nil, errors.New("unsupported ogg codec")
+	}
+	if rate == 0 || len(packets) <= headers {
+		return nil, errors.New("invalid ogg, no audio packets")
+	}
+	audio := packets[headers:]
+
+	var lastGranule int64
+	for _, p := range audio {
+		if p.granule > lastGranule {
+			lastGranule = p.granule
+		}
+	}
+	out := &audioInfo{durationMs: max(0, lastGranule-preSkip) * 1000 / rate}
+	out.waveform = normalizeWaveform(oggPacketSizeLevels(audio))
+	return out, nil
+}
+
+// oggPacketSizeLevels returns the average size of the audio packets of every
+// slice of the audio. With variable bitrate and DTX quiet parts are encoded in
+// smaller packets, so the sizes follow the loudness without being amplitudes:
+// a loud steady tone may be encoded smaller than a quiet noisy one. Packets
+// are assumed to have the same duration, as voice encoders do.
+func oggPacketSizeLevels(audio []oggPacket) []float64 {
+	buckets := min(waveformLength, len(audio))
+	sums := make([]float64, buckets)
+	counts := make([]float64, buckets)
+	for i, p := range audio {
+		bucket := i * buckets / len(audio)
+		sums[bucket] += float64(len(p.data))
+		counts[bucket]++
+	}
+	for i := range sums {
+		if counts[i] > 0 {
+			sums[i] /= counts[i]
+		}
+	}
+	return sums
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func wavChunk(id string, data []byte) []byte {
+	out := append([]byte(id), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(out[4:8], uint32(len(data)))
+	out = append(out, data...)
+	if len(data)%2 == 1 {
+		out = append(out, 0)
+	}
+	return out
+}
+
+func wavFmt(format, channels uint16, sampleRate uint32, bitsPerSample uint16) []byte {
+	out := make([]byte, 16)
+	binary.LittleEndian.PutUint16(out[0:2], format)
+	binary.LittleEndian.PutUint16(out[2:4], channels)
+	binary.LittleEndian.PutUint32(out[4:8], sampleRate)
+	blockAlign := channels * bitsPerSample / 8
+	binary.LittleEndian.PutUint32(out[8:12], sampleRate*uint32(blockAlign))
+	binary.LittleEndian.PutUint16(out[12:14], blockAlign)
+	binary.LittleEndian.PutUint16(out[14:16], bitsPerSample)
+	return out
+}
+
+func wavFile(chunks ...[]byte) []byte {
+	body := []byte("WAVE")
+	for _, c := range chunks {
+		body = append(body, c...)
+	}
+	out := append([]byte("RIFF"), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(out[4:8], uint32(len(body)))
+	return append(out, body...)
+}
+
+// pcm16 returns frames of 16-bit mono samples rising linearly to the peak.
+func pcm16(frames int, peak int16) []byte {
+	out := make([]byte, frames*2)
+	for i := 0; i < frames; i++ {
+		binary.LittleEndian.PutUint16(out[i*2:], uint16(int16(int(peak)*i/frames)))
+	}
+	return out
+}
+
+func TestAnalyzeWav(t *testing.T) {
+	float32Samples := make([]byte, 4*100)
+	for i := 0; i < 100; i++ {
+		binary.LittleEndian.PutUint32(float32Samples[i*4:], math.Float32bits(float32(i)/100))
+	}
+	extensible := append(wavFmt(0xFFFE, 1, 8000, 16), make([]byte, 10)...)
+	binary.LittleEndian.PutUint16(extensible[24:26], 1)
+	truncated := wavFile(wavChunk("fmt ", wavFmt(1, 1, 8000, 16)), wavChunk("data", pcm16(8000, 1000)))
+	binary.LittleEndian.PutUint32(truncated[40:44], 0xFFFFFFFF) // streamed recordings leave the size unset
+
+	tests := []struct {
+		name         string
+		data         []byte
+		wantDuration int64
+		wantWaveform int // length of the waveform
+	}{
+		{"pcm 16 mono", wavFile(wavChunk("fmt ", wavFmt(1, 1, 8000, 16)), wavChunk("data", pcm16(8000, 1000))), 1000, waveformLength},
+		{"pcm 16 stereo", wavFile(wavChunk("fmt ", wavFmt(1, 2, 8000, 16)), wavChunk("data", pcm16(8000, 1000))), 500, waveformLength},
+		{"pcm 8 silence", wavFile(wavChunk("fmt ", wavFmt(1, 1, 8000, 8)), wavChunk("data", bytes.Repeat([]byte{128}, 4000))), 500, waveformLength},
+		{"float 32", wavFile(wavChunk("fmt ", wavFmt(3, 1, 1000, 32)), wavChunk("data", float32Samples)), 100, waveformLength},
+		{"extensible", wavFile(wavChunk("fmt ", extensible), wavChunk("data", pcm16(800, 1000))), 100, waveformLength},
+		{"fewer frames than the waveform", wavFile(wavChunk("fmt ", wavFmt(1, 1, 8000, 16)), wavChunk("data", pcm16(10, 1000))), 1, 10},
+		{"empty data", wavFile(wavChunk("fmt ", wavFmt(1, 1, 8000, 16)), wavChunk("data", nil)), 0, 0},
+		{"partial frame", wavFile(wavChunk("fmt ", wavFmt(1, 2, 8000, 16)), wavChunk("data", pcm16(3, 1000))), 0, 1},
+		{"unknown chunks", wavFile(wavChunk("LIST", []byte("odd")), wavChunk("fmt ", wavFmt(1, 1, 8000, 16)), wavChunk("data", pcm16(800, 1000))), 100, waveformLength},
+		{"data size past the end", truncated, 1000, waveformLength},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := analyzeWav(tt.data)
+			if err != nil {
+				t.Fatalf("analyzeWav: %v", err)
+			}
+			if info.durationMs != tt.wantDuration {
+				t.Errorf("duration = %dms, want %dms", info.durationMs, tt.wantDuration)
+			}
+			if len(info.waveform) != tt.wantWaveform {
+				t.Errorf("waveform has %d values, want %d", len(info.waveform), tt.wantWaveform)
+			}
+		})
+	}
+}
+
+func TestAnalyzeWavLevels(t *testing.T) {
+	info, err := analyzeWav(wavFile(wavChunk("fmt ", wavFmt(1, 1, 8000, 16)), wavChunk("data", pcm16(6400, 16000))))
+	if err != nil {
+		t.Fatalf("analyzeWav: %v", err)
+	}
+	// the levels are the peaks of every slice, the first one ends at 1/64 of the peak
+	if info.waveform[0] != 4 || info.waveform[len(info.waveform)-1] != 255 {
+		t.Errorf("waveform goes from %d to %d, want from 4 to 255", info.waveform[0], info.waveform[len(info.waveform)-1])
+	}
+	for i := 1; i < len(info.waveform); i++ {
+		if info.waveform[i] < info.waveform[i-1] {
+			t.Fatalf("waveform of a rising signal decreases at %d: %v", i, info.waveform)
+		}
+	}
+
+	silence, err := analyzeWav(wavFile(wavChunk("fmt ", wavFmt(1, 1, 8000, 8)), wavChunk("data", bytes.Repeat([]byte{128}, 800))))
+	if err != nil {
+		t.Fatalf("analyzeWav: %v", err)
+	}
+	for _, v := range silence.waveform {
+		if v != 0 {
+			t.Fatalf("waveform of silence = %v, want zeros", silence.waveform)
+		}
+	}
+}
+
+func TestAnalyzeWavInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"not riff", append([]byte("RIFX"), wavFile(wavChunk("fmt ", wavFmt(1, 1, 8000, 16)))[4:]...)},
+		{"not wave", append(append([]byte("RIFF\x00\x00\x00\x00"), "AVI "...), wavChunk("fmt ", wavFmt(1, 1, 8000, 16))...)},
+		{"header only", wavFile()},
+		{"missing fmt", wavFile(wavChunk("data", pcm16(10, 1000)))},
+		{"missing data", wavFile(wavChunk("fmt ", wavFmt(1, 1, 8000, 16)))},
+		{"short fmt", wavFile(wavChunk("fmt ", wavFmt(1, 1, 8000, 16)[:12]), wavChunk("data", pcm16(10, 1000)))},
+		{"no channels", wavFile(wavChunk("fmt ", wavFmt(1, 0, 8000, 16)), wavChunk("data", pcm16(10, 1000)))},
+		{"no sample rate", wavFile(wavChunk("fmt ", wavFmt(1, 1, 0, 16)), wavChunk("data", pcm16(10, 1000)))},
+		{"12 bits", wavFile(wavChunk("fmt ", wavFmt(1, 1, 8000, 12)), wavChunk("data", pcm16(10, 1000)))},
+		{"0 bits", wavFile(wavChunk("fmt ", wavFmt(1, 1, 8000, 0)), wavChunk("data", pcm16(10, 1000)))},
+		{"compressed", wavFile(wavChunk("fmt ", wavFmt(2, 1, 8000, 4)), wavChunk("data", pcm16(10, 1000)))},
+		{"float 64", wavFile(wavChunk("fmt ", wavFmt(3, 1, 8000, 64)), wavChunk("data", pcm16(10, 1000)))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := analyzeWav(tt.data); err == nil {
+				t.Error("analyzeWav succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestAnalyzeWavTruncated(t *testing.T) {
+	data := wavFile(wavChunk("LIST", []byte("odd")), wavChunk("fmt ", wavFmt(1, 2, 8000, 24)), wavChunk("data", pcm16(300, 1000)))
+	for n := 0; n <= len(data); n++ {
+		// any prefix is either rejected or analyzed, never a panic
+		analyzeWav(data[:n])
+	}
+}
+
+// oggPage builds a page of the stream carrying whole packets, continued is
+// the size of the start of a packet that goes on in the next page.
+func oggPage(serial uint32, granule int64, packets [][]byte, continued []byte) []byte {
+	segments := []byte{}
+	body := []byte{}
+	for _, p := range packets {
+		for n := len(p); ; n -= 255 {
+			if n < 255 {
+				segments = append(segments, byte(n))
+				break
+			}
+			segments = append(segments, 255)
+		}
+		body = append(body, p...)
+	}
+	for range len(continued) / 255 {
+		segments = append(segments, 255)
+	}
+	body = append(body, continued...)
+
+	out := make([]byte, 27)
+	copy(out, "OggS")
+	binary.LittleEndian.PutUint64(out[6:14], uint64(granule))
+	binary.LittleEndian.PutUint32(out[14:18], serial)
+	out[26] = byte(len(segments))
+	out = append(out, segments...)
+	return append(out, body...)
+}
+
+func opusHead(preSkip uint16) []byte {
+	out := append([]byte("OpusHead"), 1, 1, 0, 0, 0x80, 0xBB, 0, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint16(out[10:12], preSkip)
+	return out
+}
+
+func vorbisHead(rate uint32) []byte {
+	out := append([]byte("\x01vorbis"), make([]byte, 23)...)
+	binary.LittleEndian.PutUint32(out[12:16], rate)
+	return out
+}
+
+func TestAnalyzeOgg(t *testing.T) {
+	const serial = 1234
+	headers := oggPage(serial, 0, [][]byte{opusHead(312)}, nil)
+	headers = append(headers, oggPage(serial, 0, [][]byte{[]byte("OpusTags")}, nil)...)
+	audio := func(sizes ...int) [][]byte {
+		out := [][]byte{}
+		for _, size := range sizes {
+			out = append(out, bytes.Repeat([]byte{1}, size))
+		}
+		return out
+	}
+	concat := func(pages ...[]byte) []byte { return bytes.Join(pages, nil) }
+	long := bytes.Repeat([]byte{2}, 600)
+
+	tests := []struct {
+		name         string
+		data         []byte
+		wantDuration int64
+		wantWaveform []byte
+	}{
+		{"opus", concat(headers, oggPage(serial, 48000+312, audio(10, 20, 40), nil)), 1000, []byte{64, 128, 255}},
+		{"opus over pages", concat(headers, oggPage(serial, 24000+312, audio(40), nil), oggPage(serial, 96000+312, audio(20), nil)), 2000, []byte{255, 128}},
+		{"packet continued in the next page", concat(headers, oggPage(serial, -1, nil, long[:510]), oggPage(serial, 48000+312, [][]byte{long[510:], long[:300]}, nil)), 1000, []byte{255, 128}},
+		{"other streams ignored", concat(headers, oggPage(99, 10*48000, audio(200), nil), oggPage(serial, 48000+312, audio(10, 10), nil)), 1000, []byte{255, 255}},
+		{"granule before the pre skip", concat(headers, oggPage(serial, 100, audio(10), nil)), 0, []byte{255}},
+		{"vorbis", concat(oggPage(serial, 0, [][]byte{vorbisHead(44100), []byte("\x03vorbis"), []byte("\x05vorbis")}, nil), oggPage(serial, 44100/2, audio(10), nil)), 500, []byte{255}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := analyzeOgg(tt.data)
+			if err != nil {
+				t.Fatalf("analyzeOgg: %v", err)
+			}
+			if info.durationMs != tt.wantDuration {
+				t.Errorf("duration = %dms, want %dms", info.durationMs, tt.wantDuration)
+			}
+			if !bytes.Equal(info.waveform, tt.wantWaveform) {
+				t.Errorf("waveform = %v, want %v", info.waveform, tt.wantWaveform)
+			}
+		})
+	}
+}
+
+func TestAnalyzeOggInvalid(t *testing.T) {
+	const serial = 1234
+	valid := bytes.Join([][]byte{
+		oggPage(serial, 0, [][]byte{opusHead(312)}, nil),
+		oggPage(serial, 0, [][]byte{[]byte("OpusTags")}, nil),
+		oggPage(serial, 48000, [][]byte{bytes.Repeat([]byte{1}, 300)}, nil),
+	}, nil)
+	badCapture := append([]byte{}, valid...)
+	copy(badCapture, "OggX")
+	tooManySegments := append([]byte{}, valid[:27]...)
+	tooManySegments[26] = 255
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"bad capture pattern", badCapture},
+		{"short page header", valid[:20]},
+		{"segments past the end", tooManySegments},
+		{"truncated body", valid[:len(valid)-1]},
+		{"trailing garbage", append(append([]byte{}, valid...), "garbage"...)},
+		{"headers only", valid[:len(valid)-len(oggPage(serial, 48000, [][]byte{bytes.Repeat([]byte{1}, 300)}, nil))]},
+		{"unknown codec", oggPage(serial, 0, [][]byte{[]byte("Speex   "), []byte("tags"), []byte("audio")}, nil)},
+		{"short opus head", oggPage(serial, 0, [][]byte{[]byte("OpusHead"), []byte("OpusTags"), []byte("audio")}, nil)},
+		{"vorbis without rate", oggPage(serial, 0, [][]byte{vorbisHead(0), []byte("c"), []byte("s"), []byte("audio")}, nil)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := analyzeOgg(tt.data); err == nil {
+				t.Error("analyzeOgg succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestAnalyzeOggTruncated(t *testing.T) {
+	const serial = 1234
+	data := bytes.Join([][]byte{
+		oggPage(serial, 0, [][]byte{opusHead(312)}, nil),
+		oggPage(serial, 0, [][]byte{[]byte("OpusTags")}, nil),
+		oggPage(serial, 48000, [][]byte{bytes.Repeat([]byte{1}, 300), []byte("x")}, nil),
+	}, nil)
+	for n := 0; n <= len(data); n++ {
+		// any prefix is either rejected or analyzed, never a panic
+		analyzeOgg(data[:n])
+	}
+}
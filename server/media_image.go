@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/buckket/go-blurhash"
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+const (
+	maxProcessedImagePixels = 50_000_000 // larger images are not processed
+	blurhashSourceSize      = 64
+)
+
+type thumbnailSize struct {
+	label   string
+	maxSide int
+}
+
+// thumbnailSizes are generated only if the image is larger than the size.
+var thumbnailSizes = []thumbnailSize{
+	{label: "small", maxSide: 160},
+	{label: "medium", maxSide: 480},
+	{label: "large", maxSide: 1080},
+}
+
+var imageDecoders = map[string]func(data []byte) (image.Image, error){
+	"image/jpeg": func(data []byte) (image.Image, error) { return jpeg.Decode(bytes.NewReader(data)) },
+	"image/png":  func(data []byte) (image.Image, error) { return png.Decode(bytes.NewReader(data)) },
+	"image/gif":  func(data []byte) (image.Image, error) { return gif.Decode(bytes.NewReader(data)) },
+	"image/webp": func(data []byte) (image.Image, error) { return webp.Decode(bytes.NewReader(data)) },
+}
+
+var imageConfigDecoders = map[string]func(data []byte) (image.Config, error){
+	"image/jpeg": func(data []byte) (image.Config, error) { return jpeg.DecodeConfig(bytes.NewReader(data)) },
+	"image/png":  func(data []byte) (image.Config, error) { return png.DecodeConfig(bytes.NewReader(data)) },
+	"image/gif":  func(data []byte) (image.Config, error) { return gif.DecodeConfig(bytes.NewReader(data)) },
+	"image/webp": func(data []byte) (image.Config, error) { return webp.DecodeConfig(bytes.NewReader(data)) },
+}
+
+func isProcessableImage(mimeType string) bool { return imageDecoders[mimeType] != nil }
+
+type processedThumbnail struct {
+	label    string
+	data     []byte
+	mimeType string
+	width    int
+	height   int
+}
+
+type processedImage struct {
+	data       []byte // the image without its metadata, nil if the original has none
+	width      int
+	height     int
+	blurhash   string
+	thumbnails []processedThumbnail
+}
+
+// processImage strips the metadata (EXIF, GPS, XMP, text chunks) of the
+// image, and computes its displayed dimensions, blurhash and thumbnails.
+func processImage(data []byte, mimeType string) (*processedImage, error) {
+	if !isProcessableImage(mimeType) {
+		return nil, errors.New("unsupported image type: " + mimeType)
+	}
+	config, err := imageConfigDecoders[mimeType](data)
+	if err != nil {
+		return nil, err
+	}
+	if config.Width*config.Height > maxProcessedImagePixels {
+		return nil, errors.New("image is too large to be processed")
+	}
+	img, err := imageDecoders[mimeType](data)
+	if err != nil {
+		return nil, err
+	}
+
+	stripped := data
+	switch mimeType {
+	case "image/jpeg":
+		// the orientation is lost along with the EXIF data, so it is applied
+		// to the pixels, which requires re-encoding the image
+		if orientation := jpegOrientation(data); orientation > 1 && orientation <= 8 {
+			img = applyOrientation(img, orientation)
+			buf := &bytes.Buffer{}
+			if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: 90}); err != nil {
+				return nil, err
+			}
+			stripped = buf.Bytes()
+		} else {
+			stripped, err = stripJPEGMetadata(data)
+		}
+	case "image/png":
+		stripped, err = stripPNGMetadata(data)
+	case "image/webp":
+		stripped, err = stripWebPMetadata(data)
+	case "image/gif":
+		stripped, err = stripGIFMetadata(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	out := &processedImage{width: bounds.Dx(), height: bounds.Dy()}
+	if !bytes.Equal(stripped, data) {
+		out.data = stripped
+	}
+
+	for _, size := range thumbnailSizes {
+		if max(out.width, out.height) <= size.maxSide {
+			continue
+		}
+		thumbnail := resizeToFit(img, size.maxSide)
+		encoded, thumbnailMimeType, err := encodeThumbnail(thumbnail)
+		if err != nil {
+			return nil, err
+		}
+		out.thumbnails = append(out.thumbnails, processedThumbnail{
+			label:    size.label,
+			data:     encoded,
+			mimeType: thumbnailMimeType,
+			width:    thumbnail.Bounds().Dx(),
+			height:   thumbnail.Bounds().Dy(),
+		})
+	}
+
+	xComponents, yComponents := 4, 3
+	if out.height > out.width {
+		xComponents, yComponents = 3, 4
+	}
+	out.blurhash, err = blurhash.Encode(xComponents, yComponents, resizeToFit(img, blurhashSourceSize))
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// resizeToFit scales the image down so that its longest side is maxSide.
+func resizeToFit(img image.Image, maxSide int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxSide && height <= maxSide {
+		return img
+	}
+	if width >= height {
+		height = max(1, height*maxSide/width)
+		width = maxSide
+	} else {
+		width = max(1, width*maxSide/height)
+		height = maxSide
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, xdraw.Src, nil)
+	return dst
+}
+
+// encodeThumbnail encodes opaque thumbnails as JPEG, and the others as PNG
+// to keep their transparency.
+func encodeThumbnail(img image.Image) ([]byte, string, error) {
+	buf := &bytes.Buffer{}
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && !opaque.Opaque() {
+		if err := png.Encode(buf, img); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "image/png", nil
+	}
+	if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "image/jpeg", nil
+}
+
+// applyOrientation transforms the image as described by the EXIF orientation
+// tag, so it is displayed upright without it.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	bounds := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	xdraw.Draw(src, src.Bounds(), img, bounds.Min, xdraw.Src)
+	w, h := bounds.Dx(), bounds.Dy()
+
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		dstW, dstH = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		for x := 0; x < dstW; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				sx, sy = w-1-x, y
+			case 3: // rotated 180°
+				sx, sy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				sx, sy = x, h-1-y
+			case 5: // transposed
+				sx, sy = y, x
+			case 6: // rotated 90° clockwise
+				sx, sy = y, h-1-x
+			case 7: // transversed
+				sx, sy = w-1-y, h-1-x
+			case 8: // rotated 90° counter clockwise
+				sx, sy = w-1-y, x
+			default:
+				sx, sy = x, y
+			}
+			si := src.PixOffset(sx, sy)
+			di := dst.PixOffset(x, y)
+			copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+	return dst
+}
+
+// jpegOrientation returns the EXIF orientation of the JPEG image, 0 if it has none.
+func jpegOrientation(data []byte) int {
+	orientation := 0
+	walkJPEGSegments(data, func(marker byte, payload []byte) bool {
+		if marker != 0xE1 || !bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+			return true
+		}
+		orientation = exifOrientation(payload[6:])
+		return false
+	})
+	return orientation
+}
+
+// exifOrientation reads the orientation tag (0x0112) from the first IFD of
+// the TIFF structure of an EXIF segment.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd+2 > len(tiff) {
+		return 0
+	}
+	entries := int(order.Uint16(tiff[ifd : ifd+2]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:entry+2]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8 : entry+10]))
+		}
+	}
+	return 0
+}
+
+// walkJPEGSegments calls fn with the marker and payload of every segment
+// before the image data, until fn returns false.
+func walkJPEGSegments(data []byte, fn func(marker byte, payload []byte) bool) (dataStart int, err error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 0, errors.New("invalid jpeg")
+	}
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xFF {
+			return 0, errors.New("invalid jpeg segment")
+		}
+		marker := data[i+1]
+		if marker == 0xFF { // fill byte
+			i++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 { // start of scan, end of image
+			return i, nil
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		if length < 2 || i+2+length > len(data) {
+			return 0, errors.New("invalid jpeg segment length")
+		}
+		if !fn(marker, data[i+4:i+2+length]) {
+			return i, nil
+		}
+		i += 2 + length
+	}
+	return 0, errors.New("invalid jpeg")
+}
+
+// jpegMetadataMarkers are APP1 (EXIF, XMP), APP13 (IPTC) and comments.
+var jpegMetadataMarkers = map[byte]bool{0xE1: true, 0xED: true, 0xFE: true}
+
+// stripJPEGMetadata drops the metadata segments of the JPEG image without
+// re-encoding it.
+func stripJPEGMetadata(data []byte) ([]byte, error) {
+	out := []byte{0xFF, 0xD8}
+	dataStart, err := walkJPEGSegments(data, func(marker byte, payload []byte) bool {
+		if !jpegMetadataMarkers[marker] {
+			out = append(out, 0xFF, marker, 0, 0)
+			binary.BigEndian.PutUint16(out[len(out)-2:], uint16(len(payload)+2))
+			out = append(out, payload...)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(out, data[dataStart:]...), nil
+}
+
+// pngMetadataChunks are the ancillary chunks carrying text, EXIF and timestamps.
+var pngMetadataChunks = map[string]bool{"tEXt": true, "zTXt": true, "iTXt": true, "eXIf": true, "tIME": true}
+
+// stripPNGMetadata drops the metadata chunks of the PNG image.
+func stripPNGMetadata(data []byte) ([]byte, error) {
+	const signatureLength = 8
+	if len(data) < signatureLength {
+		return nil, errors.New("invalid png")
+	}
+	out := append([]byte{}, data[:signatureLength]...)
+	for i := signatureLength; i < len(data); {
+		if i+8 > len(data) {
+			return nil, errors.New("invalid png chunk")
+		}
+		end := i + 12 + int(binary.BigEndian.Uint32(data[i:i+4]))
+		if end > len(data) || end < i {
+			return nil, errors.New("invalid png chunk length")
+		}
+		if !pngMetadataChunks[string(data[i+4:i+8])] {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return out, nil
+}
+
+// stripWebPMetadata drops the EXIF and XMP chunks of the WebP image, and
+// their flags from the VP8X header.
+func stripWebPMetadata(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errors.New("invalid webp")
+	}
+	out := append([]byte{}, data[:12]...)
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, errors.New("invalid webp chunk")
+		}
+		size := int(binary.LittleEndian.Uint32(data[i+4 : i+8]))
+		end := i + 8 + size + size%2
+		if end > len(data) || end < i {
+			return nil, errors.New("invalid webp chunk length")
+		}
+		switch fourCC := string(data[i : i+4]); fourCC {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte{}, data[i:end]...)
+			if len(chunk) > 8 {
+				chunk[8] &^= 0x08 | 0x04 // EXIF and XMP flags
+			}
+			out = append(out, chunk...)
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	binary.LittleEndian.PutUint32(out[4:8], uint32(len(out)-8))
+	return out, nil
+}
+
+// gifKeptApplications are the application extensions needed to display the
+// GIF image, the loop count of animations and the color profile.
+var gifKeptApplications = map[string]bool{"NETSCAPE2.0": true, "ANIMEXTS1.0": true, "ICCRGBG1012": true}
+
+// stripGIFMetadata drops the comment extensions and the application
+// extensions (XMP and others) of the GIF image.
+func stripGIFMetadata(data []byte) ([]byte, error) {
+	const headerLength = 13 // signature, version and logical screen descriptor
+	if len(data) < headerLength || string(data[:3]) != "GIF" {
+		return nil, errors.New("invalid gif")
+	}
+	i := headerLength
+	if data[10]&0x80 != 0 { // global color table
+		i += 3 << (data[10]&0x07 + 1)
+	}
+	if i > len(data) {
+		return nil, errors.New("invalid gif color table")
+	}
+	out := append([]byte{}, data[:i]...)
+
+	// skipSubBlocks returns the offset following the sub-blocks starting at j
+	skipSubBlocks := func(j int) (int, error) {
+		for {
+			if j >= len(data) {
+				return 0, errors.New("invalid gif sub-block")
+			}
+			size := int(data[j])
+			j++
+			if size == 0 {
+				return j, nil
+			}
+			j += size
+		}
+	}
+
+	for i < len(data) {
+		switch data[i] {
+		case 0x3B: // trailer, anything after it is dropped
+			return append(out, 0x3B), nil
+		case 0x21: // extension
+			if i+2 > len(data) {
+				return nil, errors.New("invalid gif extension")
+			}
+			end, err := skipSubBlocks(i + 2)
+			if err != nil {
+				return nil, err
+			}
+			keep := true
+			switch data[i+1] {
+			case 0xFE: // comment
+				keep = false
+			case 0xFF: // application
+				keep = i+14 <= end && data[i+2] == 11 && gifKeptApplications[string(data[i+3:i+14])]
+			}
+			if keep {
+				out = append(out, data[i:end]...)
+			}
+			i = end
+		case 0x2C: // image descriptor
+			j := i + 10
+			if j > len(data) {
+				return nil, errors.New("invalid gif image descriptor")
+			}
+			if data[i+9]&0x80 != 0 { // local color table
+				j += 3 << (data[i+9]&0x07 + 1)
+			}
+			j++ // LZW minimum code size
+			end, err := skipSubBlocks(j)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, data[i:end]...)
+			i = end
+		default:
+			return nil, errors.New("invalid gif block")
+		}
+	}
+	// some encoders omit the trailer
+	return append(out, 0x3B), nil
+}
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+
+ALTER TABLE "users" ADD COLUMN "last_seen_at" TIMESTAMP(0);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE "users" DROP COLUMN "last_seen_at";
+
+-- +goose StatementEnd
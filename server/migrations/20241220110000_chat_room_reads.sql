@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE TABLE "chat_room_reads" (
+  "chat_room_id" UUID NOT NULL,
+  "user_id" UUID NOT NULL,
+  "last_read_message_id" INTEGER NOT NULL,
+  "read_at" TIMESTAMP(0) NOT NULL DEFAULT (CURRENT_TIMESTAMP),
+  PRIMARY KEY ("chat_room_id", "user_id")
+);
+
+ALTER TABLE "chat_room_reads" ADD FOREIGN KEY ("chat_room_id") REFERENCES "chat_rooms" ("id") ON DELETE CASCADE ON UPDATE NO ACTION;
+
+ALTER TABLE "chat_room_reads" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE ON UPDATE NO ACTION;
+
+CREATE INDEX ON "chat_messages" ("chat_room_id", "id");
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP INDEX IF EXISTS "chat_messages_chat_room_id_id_idx";
+DROP TABLE "chat_room_reads";
+
+-- +goose StatementEnd
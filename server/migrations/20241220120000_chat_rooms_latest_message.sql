@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- the column is used by the ChatRoom model but was never part of the setup migration
+ALTER TABLE "chat_rooms" ADD COLUMN IF NOT EXISTS "latest_message_id" INTEGER;
+
+ALTER TABLE "chat_rooms" ADD CONSTRAINT "chat_rooms_latest_message_id_fkey" FOREIGN KEY ("latest_message_id") REFERENCES "chat_messages" ("id") ON DELETE SET NULL ON UPDATE NO ACTION;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE "chat_rooms" DROP CONSTRAINT "chat_rooms_latest_message_id_fkey";
+
+-- +goose StatementEnd
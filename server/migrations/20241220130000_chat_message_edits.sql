@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE TABLE "chat_message_edits" (
+  "id" SERIAL PRIMARY KEY,
+  "chat_message_id" INTEGER NOT NULL,
+  "content" TEXT NOT NULL,
+  "written_at" TIMESTAMP(0) NOT NULL,
+  "replaced_at" TIMESTAMP(0) NOT NULL DEFAULT (CURRENT_TIMESTAMP)
+);
+
+ALTER TABLE "chat_message_edits" ADD FOREIGN KEY ("chat_message_id") REFERENCES "chat_messages" ("id") ON DELETE CASCADE ON UPDATE NO ACTION;
+
+CREATE INDEX ON "chat_message_edits" ("chat_message_id");
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE "chat_message_edits";
+
+-- +goose StatementEnd
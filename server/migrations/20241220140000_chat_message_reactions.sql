@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE TABLE "chat_message_reactions" (
+  "id" SERIAL PRIMARY KEY,
+  "chat_message_id" INTEGER NOT NULL,
+  "user_id" UUID NOT NULL,
+  "emoji" VARCHAR(32) NOT NULL,
+  "created_at" TIMESTAMP(0) NOT NULL DEFAULT (CURRENT_TIMESTAMP),
+  UNIQUE ("chat_message_id", "user_id", "emoji")
+);
+
+ALTER TABLE "chat_message_reactions" ADD FOREIGN KEY ("chat_message_id") REFERENCES "chat_messages" ("id") ON DELETE CASCADE ON UPDATE NO ACTION;
+
+ALTER TABLE "chat_message_reactions" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE ON UPDATE NO ACTION;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE "chat_message_reactions";
+
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+
+ALTER TABLE "chat_messages" ADD COLUMN "reply_to_id" INTEGER;
+ALTER TABLE "chat_messages" ADD COLUMN "reply_count" INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE "chat_messages" ADD COLUMN "last_reply_at" TIMESTAMP(0);
+
+ALTER TABLE "chat_messages" ADD FOREIGN KEY ("reply_to_id") REFERENCES "chat_messages" ("id") ON DELETE SET NULL ON UPDATE NO ACTION;
+
+CREATE INDEX ON "chat_messages" ("reply_to_id", "created_at");
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE "chat_messages" DROP COLUMN "last_reply_at";
+ALTER TABLE "chat_messages" DROP COLUMN "reply_count";
+ALTER TABLE "chat_messages" DROP COLUMN "reply_to_id";
+
+-- +goose StatementEnd
@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+
+ALTER TABLE "chat_rooms" ADD COLUMN "admins_ids" VARCHAR(255)[] NOT NULL DEFAULT '{}';
+
+CREATE TABLE "chat_message_mentions" (
+  "id" SERIAL PRIMARY KEY,
+  "chat_message_id" INTEGER NOT NULL,
+  "chat_room_id" UUID NOT NULL,
+  "user_id" UUID NOT NULL,
+  "mentions_all" BOOLEAN NOT NULL DEFAULT false,
+  "position" INTEGER NOT NULL,
+  "length" INTEGER NOT NULL,
+  "created_at" TIMESTAMP(0) NOT NULL DEFAULT (CURRENT_TIMESTAMP)
+);
+
+ALTER TABLE "chat_message_mentions" ADD FOREIGN KEY ("chat_message_id") REFERENCES "chat_messages" ("id") ON DELETE CASCADE ON UPDATE NO ACTION;
+
+ALTER TABLE "chat_message_mentions" ADD FOREIGN KEY ("chat_room_id") REFERENCES "chat_rooms" ("id") ON DELETE CASCADE ON UPDATE NO ACTION;
+
+ALTER TABLE "chat_message_mentions" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE ON UPDATE NO ACTION;
+
+CREATE INDEX ON "chat_message_mentions" ("chat_message_id");
+
+CREATE INDEX ON "chat_message_mentions" ("user_id", "chat_message_id");
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE "chat_message_mentions";
+ALTER TABLE "chat_rooms" DROP COLUMN "admins_ids";
+
+-- +goose StatementEnd
@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE TABLE "attachments" (
+  "id" UUID PRIMARY KEY,
+  "uploaded_by_id" UUID NOT NULL,
+  "storage_key" TEXT NOT NULL,
+  "file_name" VARCHAR(255) NOT NULL,
+  "mime_type" VARCHAR(255) NOT NULL,
+  "size" BIGINT NOT NULL,
+  "checksum" VARCHAR(64) NOT NULL,
+  "created_at" TIMESTAMP(0) NOT NULL DEFAULT (CURRENT_TIMESTAMP),
+  "deleted_at" TIMESTAMP(0)
+);
+
+ALTER TABLE "attachments" ADD FOREIGN KEY ("uploaded_by_id") REFERENCES "users" ("id") ON DELETE CASCADE ON UPDATE NO ACTION;
+
+ALTER TABLE "chat_messages" ADD COLUMN "attachment_id" UUID;
+
+ALTER TABLE "chat_messages" ADD FOREIGN KEY ("attachment_id") REFERENCES "attachments" ("id") ON DELETE SET NULL ON UPDATE NO ACTION;
+
+CREATE INDEX ON "chat_messages" ("attachment_id");
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE "chat_messages" DROP COLUMN "attachment_id";
+DROP TABLE "attachments";
+
+-- +goose StatementEnd
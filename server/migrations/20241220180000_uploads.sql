@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE TABLE "uploads" (
+  "id" UUID PRIMARY KEY,
+  "uploaded_by_id" UUID NOT NULL,
+  "file_name" VARCHAR(255) NOT NULL,
+  "length" BIGINT NOT NULL,
+  "upload_offset" BIGINT NOT NULL DEFAULT 0,
+  "metadata" TEXT NOT NULL DEFAULT '',
+  "attachment_id" UUID,
+  "expires_at" TIMESTAMP(0) NOT NULL,
+  "created_at" TIMESTAMP(0) NOT NULL DEFAULT (CURRENT_TIMESTAMP),
+  "updated_at" TIMESTAMP(0) NOT NULL DEFAULT (CURRENT_TIMESTAMP)
+);
+
+ALTER TABLE "uploads" ADD FOREIGN KEY ("uploaded_by_id") REFERENCES "users" ("id") ON DELETE CASCADE ON UPDATE NO ACTION;
+
+ALTER TABLE "uploads" ADD FOREIGN KEY ("attachment_id") REFERENCES "attachments" ("id") ON DELETE SET NULL ON UPDATE NO ACTION;
+
+CREATE INDEX ON "uploads" ("expires_at");
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE "uploads";
+
+-- +goose StatementEnd
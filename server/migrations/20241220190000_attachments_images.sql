@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+
+ALTER TABLE "attachments" ADD COLUMN "width" INTEGER;
+ALTER TABLE "attachments" ADD COLUMN "height" INTEGER;
+ALTER TABLE "attachments" ADD COLUMN "blurhash" VARCHAR(255);
+ALTER TABLE "attachments" ADD COLUMN "processed_at" TIMESTAMP(0);
+
+CREATE TABLE "attachment_thumbnails" (
+  "attachment_id" UUID NOT NULL,
+  "label" VARCHAR(32) NOT NULL,
+  "storage_key" TEXT NOT NULL,
+  "mime_type" VARCHAR(255) NOT NULL,
+  "width" INTEGER NOT NULL,
+  "height" INTEGER NOT NULL,
+  "size" BIGINT NOT NULL,
+  "created_at" TIMESTAMP(0) NOT NULL DEFAULT (CURRENT_TIMESTAMP),
+  PRIMARY KEY ("attachment_id", "label")
+);
+
+ALTER TABLE "attachment_thumbnails" ADD FOREIGN KEY ("attachment_id") REFERENCES "attachments" ("id") ON DELETE CASCADE ON UPDATE NO ACTION;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE "attachment_thumbnails";
+ALTER TABLE "attachments" DROP COLUMN "processed_at";
+ALTER TABLE "attachments" DROP COLUMN "blurhash";
+ALTER TABLE "attachments" DROP COLUMN "height";
+ALTER TABLE "attachments" DROP COLUMN "width";
+
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE TABLE "chat_message_locations" (
+  "chat_message_id" INTEGER PRIMARY KEY,
+  "latitude" DOUBLE PRECISION NOT NULL CHECK ("latitude" BETWEEN -90 AND 90),
+  "longitude" DOUBLE PRECISION NOT NULL CHECK ("longitude" BETWEEN -180 AND 180),
+  "accuracy" DOUBLE PRECISION CHECK ("accuracy" >= 0),
+  "place_name" VARCHAR(255)
+);
+
+ALTER TABLE "chat_message_locations" ADD FOREIGN KEY ("chat_message_id") REFERENCES "chat_messages" ("id") ON DELETE CASCADE ON UPDATE NO ACTION;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE "chat_message_locations";
+
+-- +goose StatementEnd
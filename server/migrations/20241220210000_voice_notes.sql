@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+
+ALTER TABLE "attachments" ADD COLUMN "duration_ms" BIGINT;
+ALTER TABLE "attachments" ADD COLUMN "waveform" BYTEA;
+
+ALTER TABLE "chat_messages" ADD COLUMN "play_once" BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE "chat_message_plays" (
+  "chat_message_id" INTEGER NOT NULL,
+  "user_id" UUID NOT NULL,
+  "played_at" TIMESTAMP(0) NOT NULL DEFAULT (CURRENT_TIMESTAMP),
+  PRIMARY KEY ("chat_message_id", "user_id")
+);
+
+ALTER TABLE "chat_message_plays" ADD FOREIGN KEY ("chat_message_id") REFERENCES "chat_messages" ("id") ON DELETE CASCADE ON UPDATE NO ACTION;
+
+ALTER TABLE "chat_message_plays" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE ON UPDATE NO ACTION;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE "chat_message_plays";
+ALTER TABLE "chat_messages" DROP COLUMN "play_once";
+ALTER TABLE "attachments" DROP COLUMN "waveform";
+ALTER TABLE "attachments" DROP COLUMN "duration_ms";
+
+-- +goose StatementEnd
@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+
+ALTER TABLE "chat_messages" ADD COLUMN "content_tsv" TSVECTOR GENERATED ALWAYS AS (to_tsvector('simple', "content")) STORED;
+
+CREATE INDEX "chat_messages_content_tsv_idx" ON "chat_messages" USING GIN ("content_tsv");
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP INDEX IF EXISTS "chat_messages_content_tsv_idx";
+ALTER TABLE "chat_messages" DROP COLUMN "content_tsv";
+
+-- +goose StatementEnd
@@ -0,0 +1,42 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE TABLE "scheduled_messages" (
+  "id" SERIAL PRIMARY KEY,
+  "chat_room_id" UUID NOT NULL,
+  "created_by_id" UUID NOT NULL,
+  "content" TEXT NOT NULL DEFAULT '',
+  "reply_to_id" INTEGER,
+  "attachment_id" UUID,
+  "play_once" BOOLEAN NOT NULL DEFAULT FALSE,
+  "scheduled_at" TIMESTAMP(0) NOT NULL,
+  "status" VARCHAR(10) NOT NULL DEFAULT 'pending',
+  "error" TEXT,
+  "sent_message_id" INTEGER,
+  "created_at" TIMESTAMP(0) NOT NULL DEFAULT (CURRENT_TIMESTAMP),
+  "updated_at" TIMESTAMP(0) NOT NULL DEFAULT (CURRENT_TIMESTAMP)
+);
+
+ALTER TABLE "scheduled_messages" ADD FOREIGN KEY ("chat_room_id") REFERENCES "chat_rooms" ("id") ON DELETE CASCADE ON UPDATE NO ACTION;
+
+ALTER TABLE "scheduled_messages" ADD FOREIGN KEY ("created_by_id") REFERENCES "users" ("id") ON DELETE CASCADE ON UPDATE NO ACTION;
+
+ALTER TABLE "scheduled_messages" ADD FOREIGN KEY ("reply_to_id") REFERENCES "chat_messages" ("id") ON DELETE SET NULL ON UPDATE NO ACTION;
+
+ALTER TABLE "scheduled_messages" ADD FOREIGN KEY ("attachment_id") REFERENCES "attachments" ("id") ON DELETE SET NULL ON UPDATE NO ACTION;
+
+ALTER TABLE "scheduled_messages" ADD FOREIGN KEY ("sent_message_id") REFERENCES "chat_messages" ("id") ON DELETE SET NULL ON UPDATE NO ACTION;
+
+-- the scheduler only looks at the pending messages
+CREATE INDEX ON "scheduled_messages" ("scheduled_at") WHERE "status" = 'pending';
+
+CREATE INDEX ON "scheduled_messages" ("created_by_id", "status");
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE "scheduled_messages";
+
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+
+ALTER TABLE "chat_rooms" ADD COLUMN "message_ttl" INTEGER;
+
+ALTER TABLE "chat_messages" ADD COLUMN "expires_at" TIMESTAMP(0);
+
+-- the sweeper only looks at the disappearing messages
+CREATE INDEX ON "chat_messages" ("expires_at") WHERE "expires_at" IS NOT NULL;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE "chat_messages" DROP COLUMN "expires_at";
+ALTER TABLE "chat_rooms" DROP COLUMN "message_ttl";
+
+-- +goose StatementEnd
@@ -0,0 +1,50 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE TABLE "polls" (
+  "chat_message_id" INTEGER PRIMARY KEY,
+  "question" VARCHAR(255) NOT NULL,
+  "multiple_choice" BOOLEAN NOT NULL DEFAULT FALSE,
+  "anonymous" BOOLEAN NOT NULL DEFAULT FALSE,
+  "closes_at" TIMESTAMP(0),
+  "closed_at" TIMESTAMP(0)
+);
+
+ALTER TABLE "polls" ADD FOREIGN KEY ("chat_message_id") REFERENCES "chat_messages" ("id") ON DELETE CASCADE ON UPDATE NO ACTION;
+
+CREATE TABLE "poll_options" (
+  "id" SERIAL PRIMARY KEY,
+  "chat_message_id" INTEGER NOT NULL,
+  "position" INTEGER NOT NULL,
+  "text" VARCHAR(100) NOT NULL,
+  UNIQUE ("chat_message_id", "position")
+);
+
+ALTER TABLE "poll_options" ADD FOREIGN KEY ("chat_message_id") REFERENCES "polls" ("chat_message_id") ON DELETE CASCADE ON UPDATE NO ACTION;
+
+CREATE TABLE "poll_votes" (
+  "poll_option_id" INTEGER NOT NULL,
+  "user_id" UUID NOT NULL,
+  "chat_message_id" INTEGER NOT NULL,
+  "voted_at" TIMESTAMP(0) NOT NULL DEFAULT (CURRENT_TIMESTAMP),
+  PRIMARY KEY ("poll_option_id", "user_id")
+);
+
+ALTER TABLE "poll_votes" ADD FOREIGN KEY ("poll_option_id") REFERENCES "poll_options" ("id") ON DELETE CASCADE ON UPDATE NO ACTION;
+
+ALTER TABLE "poll_votes" ADD FOREIGN KEY ("chat_message_id") REFERENCES "polls" ("chat_message_id") ON DELETE CASCADE ON UPDATE NO ACTION;
+
+ALTER TABLE "poll_votes" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE ON UPDATE NO ACTION;
+
+CREATE INDEX ON "poll_votes" ("chat_message_id", "user_id");
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE "poll_votes";
+DROP TABLE "poll_options";
+DROP TABLE "polls";
+
+-- +goose StatementEnd
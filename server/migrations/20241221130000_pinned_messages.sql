@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE TABLE "chat_room_pins" (
+  "chat_message_id" INTEGER PRIMARY KEY,
+  "chat_room_id" UUID NOT NULL,
+  "pinned_by_id" UUID,
+  "pinned_at" TIMESTAMP(0) NOT NULL DEFAULT (CURRENT_TIMESTAMP)
+);
+
+ALTER TABLE "chat_room_pins" ADD FOREIGN KEY ("chat_message_id") REFERENCES "chat_messages" ("id") ON DELETE CASCADE ON UPDATE NO ACTION;
+
+ALTER TABLE "chat_room_pins" ADD FOREIGN KEY ("chat_room_id") REFERENCES "chat_rooms" ("id") ON DELETE CASCADE ON UPDATE NO ACTION;
+
+ALTER TABLE "chat_room_pins" ADD FOREIGN KEY ("pinned_by_id") REFERENCES "users" ("id") ON DELETE SET NULL ON UPDATE NO ACTION;
+
+CREATE INDEX ON "chat_room_pins" ("chat_room_id", "pinned_at");
+
+-- system messages are written by the server, e.g. when a message is pinned
+ALTER TABLE "chat_messages" ADD COLUMN "system_event" VARCHAR(32);
+ALTER TABLE "chat_messages" ADD COLUMN "target_message_id" INTEGER;
+
+ALTER TABLE "chat_messages" ADD FOREIGN KEY ("target_message_id") REFERENCES "chat_messages" ("id") ON DELETE SET NULL ON UPDATE NO ACTION;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE "chat_messages" DROP COLUMN "target_message_id";
+ALTER TABLE "chat_messages" DROP COLUMN "system_event";
+DROP TABLE "chat_room_pins";
+
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+
+ALTER TABLE "chat_messages" ADD COLUMN "forwarded_from_message_id" INTEGER;
+ALTER TABLE "chat_messages" ADD COLUMN "forwarded_from_user_id" UUID;
+ALTER TABLE "chat_messages" ADD COLUMN "forwarded_from_room_id" UUID;
+
+ALTER TABLE "chat_messages" ADD FOREIGN KEY ("forwarded_from_message_id") REFERENCES "chat_messages" ("id") ON DELETE SET NULL ON UPDATE NO ACTION;
+
+ALTER TABLE "chat_messages" ADD FOREIGN KEY ("forwarded_from_user_id") REFERENCES "users" ("id") ON DELETE SET NULL ON UPDATE NO ACTION;
+
+ALTER TABLE "chat_messages" ADD FOREIGN KEY ("forwarded_from_room_id") REFERENCES "chat_rooms" ("id") ON DELETE SET NULL ON UPDATE NO ACTION;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE "chat_messages" DROP COLUMN "forwarded_from_room_id";
+ALTER TABLE "chat_messages" DROP COLUMN "forwarded_from_user_id";
+ALTER TABLE "chat_messages" DROP COLUMN "forwarded_from_message_id";
+
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE TABLE "chat_message_stars" (
+  "chat_message_id" INTEGER NOT NULL,
+  "user_id" UUID NOT NULL,
+  "note" VARCHAR(500),
+  "starred_at" TIMESTAMP(0) NOT NULL DEFAULT (CURRENT_TIMESTAMP),
+  PRIMARY KEY ("chat_message_id", "user_id")
+);
+
+ALTER TABLE "chat_message_stars" ADD FOREIGN KEY ("chat_message_id") REFERENCES "chat_messages" ("id") ON DELETE CASCADE ON UPDATE NO ACTION;
+
+ALTER TABLE "chat_message_stars" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE ON UPDATE NO ACTION;
+
+CREATE INDEX ON "chat_message_stars" ("user_id", "starred_at");
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE "chat_message_stars";
+
+-- +goose StatementEnd
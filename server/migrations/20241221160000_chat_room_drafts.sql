@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE TABLE "chat_room_drafts" (
+  "chat_room_id" UUID NOT NULL,
+  "user_id" UUID NOT NULL,
+  "content" TEXT NOT NULL,
+  "updated_at" TIMESTAMP(0) NOT NULL DEFAULT (CURRENT_TIMESTAMP),
+  PRIMARY KEY ("chat_room_id", "user_id")
+);
+
+ALTER TABLE "chat_room_drafts" ADD FOREIGN KEY ("chat_room_id") REFERENCES "chat_rooms" ("id") ON DELETE CASCADE ON UPDATE NO ACTION;
+
+ALTER TABLE "chat_room_drafts" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE ON UPDATE NO ACTION;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE "chat_room_drafts";
+
+-- +goose StatementEnd
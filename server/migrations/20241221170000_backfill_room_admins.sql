@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- the groups created before the admins were introduced have none, every
+-- member becomes an admin so that the admin only actions remain possible
+UPDATE "chat_rooms" SET "admins_ids" = "users_ids"
+WHERE "peer_to_peer" IS NOT TRUE AND "admins_ids" = '{}';
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+SELECT 1;
+
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+
+ALTER TABLE "attachments" ADD COLUMN "processing_failed_at" TIMESTAMP(0);
+
+-- the images that failed before were marked as processed without dimensions
+UPDATE "attachments" SET "processing_failed_at" = "processed_at"
+WHERE "processed_at" IS NOT NULL
+  AND "width" IS NULL
+  AND "mime_type" IN ('image/jpeg', 'image/png', 'image/gif', 'image/webp');
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE "attachments" DROP COLUMN "processing_failed_at";
+
+-- +goose StatementEnd
@@ -0,0 +1,41 @@
+-- +goose Up
+-- +goose StatementBegin
+
+ALTER TABLE "chat_messages" ADD COLUMN "thread_root_id" INTEGER;
+
+ALTER TABLE "chat_messages" ADD FOREIGN KEY ("thread_root_id") REFERENCES "chat_messages" ("id") ON DELETE SET NULL ON UPDATE NO ACTION;
+
+-- the replies to a reply belong to the thread of its root
+WITH RECURSIVE "threads" AS (
+  SELECT "id", "id" AS "root_id" FROM "chat_messages" WHERE "reply_to_id" IS NULL
+  UNION ALL
+  SELECT "chat_messages"."id", "threads"."root_id" FROM "chat_messages"
+  JOIN "threads" ON "chat_messages"."reply_to_id" = "threads"."id"
+)
+UPDATE "chat_messages" SET "thread_root_id" = "threads"."root_id"
+FROM "threads"
+WHERE "chat_messages"."id" = "threads"."id"
+  AND "chat_messages"."reply_to_id" IS NOT NULL;
+
+UPDATE "chat_messages" SET "reply_count" = 0, "last_reply_at" = NULL
+WHERE "reply_count" <> 0 OR "last_reply_at" IS NOT NULL;
+
+UPDATE "chat_messages" SET "reply_count" = "replies"."count", "last_reply_at" = "replies"."last_reply_at"
+FROM (
+  SELECT "thread_root_id", COUNT(*) AS "count", MAX("created_at") AS "last_reply_at"
+  FROM "chat_messages"
+  WHERE "thread_root_id" IS NOT NULL AND "deleted_at" IS NULL
+  GROUP BY "thread_root_id"
+) AS "replies"
+WHERE "chat_messages"."id" = "replies"."thread_root_id";
+
+CREATE INDEX ON "chat_messages" ("thread_root_id", "created_at");
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE "chat_messages" DROP COLUMN "thread_root_id";
+
+-- +goose StatementEnd
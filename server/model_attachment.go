@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Attachment is an uploaded file, messages reference it by id.
+type Attachment struct {
+	ID UUID `json:"id" gorm:"primaryKey"`
+
+	UploadedByID UUID  `json:"uploaded_by_id" gorm:"column:uploaded_by_id"`
+	UploadedBy   *User `json:"uploaded_by,omitempty" gorm:"foreignKey:UploadedByID;references:ID"`
+
+	StorageKey string `json:"-" gorm:"column:storage_key"`
+	FileName   string `json:"file_name" gorm:"column:file_name"`
+	MimeType   string `json:"mime_type" gorm:"column:mime_type"`
+	Size       int64  `json:"size" gorm:"column:size"`
+	Checksum   string `json:"checksum" gorm:"column:checksum"` // sha256, hex encoded
+
+	// set once an image is processed, see processAttachmentImage
+	Width              *int                  `json:"width" gorm:"column:width"`
+	Height             *int                  `json:"height" gorm:"column:height"`
+	Blurhash           *string               `json:"blurhash" gorm:"column:blurhash"`
+	Thumbnails         []AttachmentThumbnail `json:"thumbnails,omitempty" gorm:"foreignKey:AttachmentID;references:ID"`
+	ProcessedAt        *time.Time            `json:"processed_at" gorm:"column:processed_at"`
+	ProcessingFailedAt *time.Time            `json:"processing_failed_at" gorm:"column:processing_failed_at"` // only its uploader can download an image that kept its metadata
+
+	// set at upload for voice notes, see analyzeAudio
+	DurationMs *int64 `json:"duration_ms" gorm:"column:duration_ms"`
+	Waveform   []byte `json:"-" gorm:"column:waveform"`
+
+	CreatedAt time.Time      `json:"created_at" gorm:"column:created_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+func (Attachment) TableName() string { return "attachments" }
+
+func (a *Attachment) BeforeCreate(tx *gorm.DB) (err error) {
+	if a.ID.IsEmpty() {
+		a.ID = NewUUIDv4()
+	}
+	a.CreatedAt = time.Now()
+	return
+}
+
+// NeedsImageProcessing reports whether the attachment is an image handled by
+// the image processing pipeline that is not yet processed.
+func (a *Attachment) NeedsImageProcessing() bool {
+	return a.ProcessedAt == nil && isProcessableImage(a.MimeType)
+}
+
+// ProcessingFailed reports whether the image could not be processed.
+func (a *Attachment) ProcessingFailed() bool {
+	return a.ProcessingFailedAt != nil
+}
+
+// MessageType returns the type of the messages carrying this attachment.
+func (a *Attachment) MessageType() CMType {
+	switch {
+	case strings.HasPrefix(a.MimeType, "image/"):
+		return CMTypeImage
+	case strings.HasPrefix(a.MimeType, "video/"):
+		return CMTypeVideo
+	case strings.HasPrefix(a.MimeType, "audio/"):
+		return CMTypeAudio
+	default:
+		return CMTypeDocument
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"time"
+)
+
+// AttachmentThumbnail is a downscaled version of an image attachment, an
+// image has at most one thumbnail per label.
+type AttachmentThumbnail struct {
+	AttachmentID UUID   `json:"attachment_id" gorm:"primaryKey;column:attachment_id"`
+	Label        string `json:"label" gorm:"primaryKey;column:label"`
+
+	StorageKey string `json:"-" gorm:"column:storage_key"`
+	MimeType   string `json:"mime_type" gorm:"column:mime_type"`
+	Width      int    `json:"width" gorm:"column:width"`
+	Height     int    `json:"height" gorm:"column:height"`
+	Size       int64  `json:"size" gorm:"column:size"`
+
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+}
+
+func (AttachmentThumbnail) TableName() string { return "attachment_thumbnails" }
@@ -15,6 +15,15 @@ const (
 	CMTypeVideo    CMType = "video"
 	CMTypeAudio    CMType = "audio"
 	CMTypeLocation CMType = "location"
+	CMTypePoll     CMType = "poll"
+	CMTypeSystem   CMType = "system" // written by the server, see SystemEvent
+)
+
+type SystemEvent string
+
+const (
+	SystemEventMessagePinned   SystemEvent = "message_pinned"
+	SystemEventMessageUnpinned SystemEvent = "message_unpinned"
 )
 
 type ChatMessage struct {
@@ -29,6 +38,32 @@ type ChatMessage struct {
 	Content string `json:"content" gorm:"column:content"`
 	Type    CMType `json:"type" gorm:"column:type"`
 
+	ReplyToID    *uint        `json:"reply_to_id,omitempty" gorm:"column:reply_to_id"`
+	ReplyTo      *ChatMessage `json:"reply_to,omitempty" gorm:"foreignKey:ReplyToID;references:ID"`
+	ThreadRootID *uint        `json:"thread_root_id,omitempty" gorm:"column:thread_root_id"` // the first message of the thread, the counters are kept on it
+	ReplyCount   int          `json:"reply_count" gorm:"column:reply_count"`
+	LastReplyAt  *time.Time   `json:"last_reply_at" gorm:"column:last_reply_at"`
+
+	AttachmentID *UUID       `json:"attachment_id,omitempty" gorm:"column:attachment_id"`
+	Attachment   *Attachment `json:"attachment,omitempty" gorm:"foreignKey:AttachmentID;references:ID"`
+
+	Location *ChatMessageLocation `json:"location,omitempty" gorm:"foreignKey:ChatMessageID;references:ID"`
+	Poll     *Poll                `json:"poll,omitempty" gorm:"foreignKey:ChatMessageID;references:ID"`
+
+	PlayOnce bool `json:"play_once" gorm:"column:play_once"` // voice notes the other members can only play once
+
+	ExpiresAt *time.Time `json:"expires_at,omitempty" gorm:"column:expires_at"` // disappearing messages are hard deleted once passed
+
+	// only for the system messages, the event and the message it is about
+	SystemEvent     *SystemEvent `json:"system_event,omitempty" gorm:"column:system_event"`
+	TargetMessageID *uint        `json:"target_message_id,omitempty" gorm:"column:target_message_id"`
+
+	// only for the forwarded messages, where the message was first sent from
+	ForwardedFromMessageID *uint `json:"forwarded_from_message_id,omitempty" gorm:"column:forwarded_from_message_id"`
+	ForwardedFromUserID    *UUID `json:"forwarded_from_user_id,omitempty" gorm:"column:forwarded_from_user_id"`
+	ForwardedFromUser      *User `json:"forwarded_from_user,omitempty" gorm:"foreignKey:ForwardedFromUserID;references:ID"`
+	ForwardedFromRoomID    *UUID `json:"forwarded_from_room_id,omitempty" gorm:"column:forwarded_from_room_id"`
+
 	CreatedAt time.Time      `json:"created_at" gorm:"column:created_at"`
 	EditedAt  *time.Time     `json:"edited_at" gorm:"column:edited_at"`
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
@@ -36,6 +71,13 @@ type ChatMessage struct {
 
 func (ChatMessage) TableName() string { return "chat_messages" }
 
+// IsForwarded reports whether the message was forwarded from another one, the
+// references are set to null when the original rows are deleted so any of them
+// is enough.
+func (msg *ChatMessage) IsForwarded() bool {
+	return msg.ForwardedFromMessageID != nil || msg.ForwardedFromUserID != nil || msg.ForwardedFromRoomID != nil
+}
+
 func (msg *ChatMessage) BeforeCreate(tx *gorm.DB) (err error) {
 	n := time.Now()
 	msg.CreatedAt = n
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"time"
+)
+
+// ChatMessageEdit is a previous version of a message content, stored every
+// time the message is edited.
+type ChatMessageEdit struct {
+	ID uint `json:"id" gorm:"primaryKey"`
+
+	ChatMessageID uint `json:"chat_message_id" gorm:"column:chat_message_id"`
+
+	Content string `json:"content" gorm:"column:content"`
+
+	// when the content was first sent, or set by a previous edit
+	WrittenAt time.Time `json:"written_at" gorm:"column:written_at"`
+	// when the content was replaced by a newer version
+	ReplacedAt time.Time `json:"replaced_at" gorm:"column:replaced_at"`
+}
+
+func (ChatMessageEdit) TableName() string { return "chat_message_edits" }
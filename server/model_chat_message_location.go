@@ -0,0 +1,13 @@
+package main
+
+// ChatMessageLocation is the location sent by a message of type CMTypeLocation.
+type ChatMessageLocation struct {
+	ChatMessageID uint `json:"chat_message_id" gorm:"primaryKey;column:chat_message_id"`
+
+	Latitude  float64  `json:"latitude" gorm:"column:latitude"`
+	Longitude float64  `json:"longitude" gorm:"column:longitude"`
+	Accuracy  *float64 `json:"accuracy" gorm:"column:accuracy"` // in meters
+	PlaceName *string  `json:"place_name" gorm:"column:place_name"`
+}
+
+func (ChatMessageLocation) TableName() string { return "chat_message_locations" }
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"time"
+)
+
+// ChatMessageMention is a mention of a user inside a message content. An @all
+// mention is stored once per mentioned member with MentionsAll set.
+type ChatMessageMention struct {
+	ID uint `json:"id" gorm:"primaryKey"`
+
+	ChatMessageID uint `json:"chat_message_id" gorm:"column:chat_message_id"`
+	ChatRoomID    UUID `json:"chat_room_id" gorm:"column:chat_room_id"`
+	UserID        UUID `json:"user_id" gorm:"column:user_id"`
+
+	MentionsAll bool `json:"mentions_all" gorm:"column:mentions_all"`
+	Position    int  `json:"position" gorm:"column:position"` // in runes, position of the @ sign
+	Length      int  `json:"length" gorm:"column:length"`     // in runes, including the @ sign
+
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+}
+
+func (ChatMessageMention) TableName() string { return "chat_message_mentions" }
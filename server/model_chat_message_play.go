@@ -0,0 +1,15 @@
+package main
+
+import (
+	"time"
+)
+
+// ChatMessagePlay records that a member of the room played a voice note.
+type ChatMessagePlay struct {
+	ChatMessageID uint `json:"chat_message_id" gorm:"primaryKey;column:chat_message_id"`
+	UserID        UUID `json:"user_id" gorm:"primaryKey;column:user_id"`
+
+	PlayedAt time.Time `json:"played_at" gorm:"column:played_at"`
+}
+
+func (ChatMessagePlay) TableName() string { return "chat_message_plays" }
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"time"
+)
+
+type ChatMessageReaction struct {
+	ID uint `json:"id" gorm:"primaryKey"`
+
+	ChatMessageID uint `json:"chat_message_id" gorm:"column:chat_message_id"`
+	UserID        UUID `json:"user_id" gorm:"column:user_id"`
+
+	Emoji string `json:"emoji" gorm:"column:emoji"`
+
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+}
+
+func (ChatMessageReaction) TableName() string { return "chat_message_reactions" }
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"time"
+)
+
+// ChatMessageStar is a message the user saved to find it later, along with an
+// optional note only the user sees.
+type ChatMessageStar struct {
+	ChatMessageID uint         `json:"chat_message_id" gorm:"primaryKey;column:chat_message_id"`
+	ChatMessage   *ChatMessage `json:"chat_message,omitempty" gorm:"foreignKey:ChatMessageID;references:ID"`
+	UserID        UUID         `json:"user_id" gorm:"primaryKey;column:user_id"`
+
+	Note      *string   `json:"note" gorm:"column:note"`
+	StarredAt time.Time `json:"starred_at" gorm:"column:starred_at"`
+}
+
+func (ChatMessageStar) TableName() string { return "chat_message_stars" }
@@ -13,10 +13,13 @@ type ChatRoom struct {
 	UsersLimit int         `json:"users_limit" gorm:"column:users_limit"`
 	UsersIDs   StringArray `json:"users_ids" gorm:"column:users_ids"`
 	PeerToPeer *bool       `json:"peer_to_peer" gorm:"column:peer_to_peer"` // not null, but we are using gorm
+	AdminsIDs  StringArray `json:"admins_ids" gorm:"column:admins_ids"`     // only used by group rooms
 
 	LatestMessageID *uint        `json:"latest_message_id,omitempty" gorm:"column:latest_message_id"`
 	LatestMessage   *ChatMessage `json:"latest_message,omitempty" gorm:"foreignKey:LatestMessageID;references:ID"`
 
+	MessageTTL *int `json:"message_ttl,omitempty" gorm:"column:message_ttl"` // seconds, see messageTTL
+
 	CreatedAt time.Time      `json:"created_at" gorm:"column:created_at"`
 	UpdatedAt time.Time      `json:"updated_at" gorm:"column:updated_at"`
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
@@ -35,6 +38,9 @@ func (cr *ChatRoom) BeforeCreate(tx *gorm.DB) (err error) {
 		b := true
 		cr.PeerToPeer = &b
 	}
+	if cr.AdminsIDs == nil {
+		cr.AdminsIDs = StringArray{}
+	}
 	n := time.Now()
 	cr.CreatedAt = n
 	cr.UpdatedAt = n
@@ -45,3 +51,16 @@ func (cr *ChatRoom) BeforeUpdate(tx *gorm.DB) (err error) {
 	cr.UpdatedAt = time.Now()
 	return
 }
+
+func (cr *ChatRoom) IsPrivate() bool {
+	return len(cr.UsersIDs) == 2 && cr.PeerToPeer != nil && *cr.PeerToPeer
+}
+
+func (cr *ChatRoom) IsAdmin(userID string) bool {
+	for _, id := range cr.AdminsIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"time"
+)
+
+// ChatRoomDraft is the message a user started writing in a room and did not
+// send yet, kept on the server so that every device of the user shows it.
+type ChatRoomDraft struct {
+	ChatRoomID UUID `json:"chat_room_id" gorm:"primaryKey;column:chat_room_id"`
+	UserID     UUID `json:"user_id" gorm:"primaryKey;column:user_id"`
+
+	Content   string    `json:"content" gorm:"column:content"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func (ChatRoomDraft) TableName() string { return "chat_room_drafts" }
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"time"
+)
+
+// ChatRoomPin is a message pinned to the top of its room.
+type ChatRoomPin struct {
+	ChatMessageID uint         `json:"chat_message_id" gorm:"primaryKey;column:chat_message_id"`
+	ChatMessage   *ChatMessage `json:"chat_message,omitempty" gorm:"foreignKey:ChatMessageID;references:ID"`
+	ChatRoomID    UUID         `json:"chat_room_id" gorm:"column:chat_room_id"`
+	PinnedByID    *UUID        `json:"pinned_by_id" gorm:"column:pinned_by_id"`
+
+	PinnedAt time.Time `json:"pinned_at" gorm:"column:pinned_at"`
+}
+
+func (ChatRoomPin) TableName() string { return "chat_room_pins" }
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"time"
+)
+
+// ChatRoomRead is the read pointer of a user in a room, every message with an
+// id lower than or equal to LastReadMessageID is considered seen by the user.
+type ChatRoomRead struct {
+	ChatRoomID UUID `json:"chat_room_id" gorm:"primaryKey;column:chat_room_id"`
+	UserID     UUID `json:"user_id" gorm:"primaryKey;column:user_id"`
+
+	LastReadMessageID uint      `json:"last_read_message_id" gorm:"column:last_read_message_id"`
+	ReadAt            time.Time `json:"read_at" gorm:"column:read_at"`
+}
+
+func (ChatRoomRead) TableName() string { return "chat_room_reads" }
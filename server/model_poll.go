@@ -0,0 +1,47 @@
+package main
+
+import (
+	"time"
+)
+
+// Poll is the poll sent by a message of type CMTypePoll, the question is
+// also the content of the message.
+type Poll struct {
+	ChatMessageID uint `json:"chat_message_id" gorm:"primaryKey;column:chat_message_id"`
+
+	Question       string       `json:"question" gorm:"column:question"`
+	MultipleChoice bool         `json:"multiple_choice" gorm:"column:multiple_choice"`
+	Anonymous      bool         `json:"anonymous" gorm:"column:anonymous"` // the voters of the options are not shown
+	Options        []PollOption `json:"options,omitempty" gorm:"foreignKey:ChatMessageID;references:ChatMessageID"`
+
+	ClosesAt *time.Time `json:"closes_at" gorm:"column:closes_at"`
+	ClosedAt *time.Time `json:"closed_at" gorm:"column:closed_at"` // set when the creator closes the poll early
+}
+
+func (Poll) TableName() string { return "polls" }
+
+// IsClosed reports whether the poll no longer accepts votes.
+func (p *Poll) IsClosed() bool {
+	return p.ClosedAt != nil || (p.ClosesAt != nil && !p.ClosesAt.After(time.Now()))
+}
+
+type PollOption struct {
+	ID            uint   `json:"id" gorm:"primaryKey"`
+	ChatMessageID uint   `json:"chat_message_id" gorm:"column:chat_message_id"`
+	Position      int    `json:"position" gorm:"column:position"`
+	Text          string `json:"text" gorm:"column:text"`
+}
+
+func (PollOption) TableName() string { return "poll_options" }
+
+// PollVote is the vote of a member of the room for an option, a member has
+// at most one vote per option, and one vote per poll if it is single choice.
+type PollVote struct {
+	PollOptionID  uint `json:"poll_option_id" gorm:"primaryKey;column:poll_option_id"`
+	UserID        UUID `json:"user_id" gorm:"primaryKey;column:user_id"`
+	ChatMessageID uint `json:"chat_message_id" gorm:"column:chat_message_id"`
+
+	VotedAt time.Time `json:"voted_at" gorm:"column:voted_at"`
+}
+
+func (PollVote) TableName() string { return "poll_votes" }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type ScheduledMessageStatus string
+
+const (
+	SMStatusPending ScheduledMessageStatus = "pending"
+	SMStatusSent    ScheduledMessageStatus = "sent"
+	SMStatusFailed  ScheduledMessageStatus = "failed"
+)
+
+// ScheduledMessage is a message to be sent to a room at ScheduledAt, it
+// becomes a ChatMessage once the scheduler delivers it.
+type ScheduledMessage struct {
+	ID uint `json:"id" gorm:"primaryKey"`
+
+	ChatRoomID UUID      `json:"chat_room_id" gorm:"column:chat_room_id"`
+	ChatRoom   *ChatRoom `json:"chat_room,omitempty" gorm:"foreignKey:ChatRoomID;references:ID"`
+
+	CreatedByID UUID  `json:"created_by_id" gorm:"column:created_by_id"`
+	CreatedBy   *User `json:"created_by,omitempty" gorm:"foreignKey:CreatedByID;references:ID"`
+
+	Content      string `json:"content" gorm:"column:content"`
+	ReplyToID    *uint  `json:"reply_to_id,omitempty" gorm:"column:reply_to_id"`
+	AttachmentID *UUID  `json:"attachment_id,omitempty" gorm:"column:attachment_id"`
+	PlayOnce     bool   `json:"play_once" gorm:"column:play_once"`
+
+	ScheduledAt   time.Time              `json:"scheduled_at" gorm:"column:scheduled_at"`
+	Status        ScheduledMessageStatus `json:"status" gorm:"column:status"`
+	Error         *string                `json:"error,omitempty" gorm:"column:error"` // why the delivery failed
+	SentMessageID *uint                  `json:"sent_message_id,omitempty" gorm:"column:sent_message_id"`
+
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func (ScheduledMessage) TableName() string { return "scheduled_messages" }
+
+func (sm *ScheduledMessage) BeforeCreate(tx *gorm.DB) (err error) {
+	n := time.Now()
+	sm.CreatedAt = n
+	sm.UpdatedAt = n
+	if sm.Status == "" {
+		sm.Status = SMStatusPending
+	}
+	return
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Upload is a resumable (tus) upload, its data is kept in a temporary file
+// until it is complete and turned into an attachment.
+type Upload struct {
+	ID UUID `json:"id" gorm:"primaryKey"`
+
+	UploadedByID UUID  `json:"uploaded_by_id" gorm:"column:uploaded_by_id"`
+	UploadedBy   *User `json:"uploaded_by,omitempty" gorm:"foreignKey:UploadedByID;references:ID"`
+
+	FileName string `json:"file_name" gorm:"column:file_name"`
+	Length   int64  `json:"length" gorm:"column:length"`
+	Offset   int64  `json:"offset" gorm:"column:upload_offset"`
+	Metadata string `json:"-" gorm:"column:metadata"` // the raw Upload-Metadata header
+
+	AttachmentID *UUID       `json:"attachment_id" gorm:"column:attachment_id"`
+	Attachment   *Attachment `json:"attachment,omitempty" gorm:"foreignKey:AttachmentID;references:ID"`
+
+	ExpiresAt time.Time `json:"expires_at" gorm:"column:expires_at"`
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func (Upload) TableName() string { return "uploads" }
+
+func (up *Upload) BeforeCreate(tx *gorm.DB) (err error) {
+	if up.ID.IsEmpty() {
+		up.ID = NewUUIDv4()
+	}
+	n := time.Now()
+	up.CreatedAt = n
+	up.UpdatedAt = n
+	return
+}
+
+func (up *Upload) IsComplete() bool { return up.Offset == up.Length }
@@ -14,6 +14,8 @@ type User struct {
 	Email            string  `json:"email" gorm:"column:email"`
 	Password         string  `json:"-" gorm:"column:password"`
 
+	LastSeenAt *time.Time `json:"last_seen_at" gorm:"column:last_seen_at"`
+
 	CreatedAt time.Time      `json:"created_at" gorm:"column:created_at"`
 	UpdatedAt time.Time      `json:"updated_at" gorm:"column:updated_at"`
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
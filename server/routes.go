@@ -1,6 +1,10 @@
 package main
 
 import (
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/adaptor"
 )
@@ -28,6 +32,47 @@ func SetupRouters(app *fiber.App) {
 		chatApis.Post("/create-group-room", AuthMiddleware(), handleCreateGroupRoom)
 		chatApis.Get("/room-messages/:room_id", AuthMiddleware(), handleRoomMessages)
 		chatApis.Post("/send-message-sync", AuthMiddleware(), handleSendMessage)
+		chatApis.Get("/presence", AuthMiddleware(), handleUsersPresence)
+		chatApis.Post("/rooms/:room_id/read", AuthMiddleware(), handleMarkRoomAsRead)
+		chatApis.Get("/rooms/:room_id/read-receipts", AuthMiddleware(), handleRoomReadReceipts)
+		chatApis.Get("/rooms/:room_id/live-locations", AuthMiddleware(), handleRoomLiveLocations)
+		chatApis.Put("/rooms/:room_id/message-ttl", AuthMiddleware(), handleSetRoomMessageTTL)
+		chatApis.Get("/rooms/:room_id/pins", AuthMiddleware(), handleRoomPinnedMessages)
+		chatApis.Put("/rooms/:room_id/pins/:message_id", AuthMiddleware(), handlePinMessage)
+		chatApis.Delete("/rooms/:room_id/pins/:message_id", AuthMiddleware(), handleUnpinMessage)
+		chatApis.Put("/rooms/:room_id/draft", AuthMiddleware(), handleSaveDraft)
+		chatApis.Patch("/messages/:message_id", AuthMiddleware(), handleEditMessage)
+		chatApis.Delete("/messages/:message_id", AuthMiddleware(), handleDeleteMessage)
+		chatApis.Get("/messages/:message_id/history", AuthMiddleware(), handleMessageEditHistory)
+		chatApis.Post("/messages/:message_id/reactions", AuthMiddleware(), handleAddReaction)
+		chatApis.Get("/messages/:message_id/replies", AuthMiddleware(), handleMessageReplies)
+		chatApis.Post("/messages/:message_id/played", AuthMiddleware(), handleVoiceNotePlayed)
+		chatApis.Post("/messages/:message_id/poll/votes", AuthMiddleware(), handleVotePoll)
+		chatApis.Post("/messages/:message_id/poll/close", AuthMiddleware(), handleClosePoll)
+		chatApis.Post("/messages/forward", AuthMiddleware(), handleForwardMessages)
+		chatApis.Put("/messages/:message_id/star", AuthMiddleware(), handleStarMessage)
+		chatApis.Delete("/messages/:message_id/star", AuthMiddleware(), handleUnstarMessage)
+		chatApis.Get("/starred-messages", AuthMiddleware(), handleStarredMessages)
+		chatApis.Get("/mentions", AuthMiddleware(), handleMyMentions)
+		chatApis.Get("/search", AuthMiddleware(), handleSearchMessages)
+		chatApis.Post("/scheduled-messages", AuthMiddleware(), handleScheduleMessage)
+		chatApis.Get("/scheduled-messages", AuthMiddleware(), handleScheduledMessages)
+		chatApis.Patch("/scheduled-messages/:scheduled_message_id", AuthMiddleware(), handleUpdateScheduledMessage)
+		chatApis.Delete("/scheduled-messages/:scheduled_message_id", AuthMiddleware(), handleCancelScheduledMessage)
+		chatApis.Delete("/messages/:message_id/reactions", AuthMiddleware(), handleRemoveReaction)
+		chatApis.Post("/attachments", AuthMiddleware(), handleUploadAttachment)
+		// browsers can not set headers on <img> and <video> tags, the token can be sent in the query
+		chatApis.Get("/attachments/:attachment_id", WSAuthMiddleware(), handleDownloadAttachment)
+		chatApis.Get("/attachments/:attachment_id/thumbnails/:label", WSAuthMiddleware(), handleDownloadAttachmentThumbnail)
+	}
+	// resumable uploads (tus)
+	{
+		uploadsApis := apiV1.Group("/uploads", TusMiddleware())
+		uploadsApis.Options("/", handleTusOptions)
+		uploadsApis.Post("/", AuthMiddleware(), handleCreateUpload)
+		uploadsApis.Head("/:upload_id", AuthMiddleware(), handleUploadOffset)
+		uploadsApis.Patch("/:upload_id", AuthMiddleware(), handlePatchUpload)
+		uploadsApis.Delete("/:upload_id", AuthMiddleware(), handleTerminateUpload)
 	}
 	// websockets
 	apiV1.Get("/ws/chat", WSAuthMiddleware(), adaptor.HTTPHandlerFunc(HandleChatWS))
@@ -156,6 +201,34 @@ func handleRoomMessages(c *fiber.Ctx) error {
 	if roomID == "" {
 		return fiber.NewError(fiber.StatusBadRequest, "room_id is required")
 	}
+	// jump to a message, around=<message_id>, before=<message_id> or after=<message_id>
+	anchors := map[HistoryAnchorMode]string{}
+	for _, mode := range []HistoryAnchorMode{HistoryAround, HistoryBefore, HistoryAfter} {
+		if v := c.Query(string(mode)); v != "" {
+			anchors[mode] = v
+		}
+	}
+	if len(anchors) > 1 {
+		return fiber.NewError(fiber.StatusBadRequest, "only one of around, before or after can be set")
+	}
+	for mode, v := range anchors {
+		anchorID, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || anchorID == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid "+string(mode))
+		}
+		out, err := GetRoomMessagesWindow(&RoomMessagesWindowInput{
+			U:        user,
+			RoomID:   roomID,
+			Mode:     mode,
+			AnchorID: uint(anchorID),
+			Limit:    c.QueryInt("limit"),
+		})
+		if err != nil {
+			return err
+		}
+		return c.JSON(out)
+	}
+
 	out, err := GetRoomMessages(c, user, roomID)
 	if err != nil {
 		return err
@@ -170,22 +243,685 @@ func handleSendMessage(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
 	}
 	type P struct {
-		OtherUserID string `json:"other_user_id" validate:"omitempty,uuid"`
-		RoomID      string `json:"room_id" validate:"omitempty,uuid"`
-		Content     string `json:"content" validate:"required,max=255"`
+		OtherUserID  string           `json:"other_user_id" validate:"omitempty,uuid"`
+		RoomID       string           `json:"room_id" validate:"omitempty,uuid"`
+		Content      string           `json:"content" validate:"required_without_all=AttachmentID Location Poll,max=255"`
+		ReplyToID    *uint            `json:"reply_to_id" validate:"omitempty,gt=0"`
+		AttachmentID string           `json:"attachment_id" validate:"omitempty,uuid"`
+		Location     *LocationPayload `json:"location" validate:"omitempty"`
+		PlayOnce     bool             `json:"play_once"`
+		TTLSeconds   *int             `json:"ttl_seconds" validate:"omitempty,min=0"`
+		Poll         *PollPayload     `json:"poll" validate:"omitempty"`
 	}
 	var payload P
 	if err := ParseAndValidate(c, &payload); err != nil {
 		return err
 	}
 	err := SendMessageSync(&SendMessageInput{
+		U:            user,
+		OtherUserID:  payload.OtherUserID,
+		RoomID:       payload.RoomID,
+		Content:      payload.Content,
+		ReplyToID:    payload.ReplyToID,
+		AttachmentID: payload.AttachmentID,
+		Location:     payload.Location,
+		PlayOnce:     payload.PlayOnce,
+		TTLSeconds:   payload.TTLSeconds,
+		Poll:         payload.Poll,
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(fiber.Map{"message": "message sent"})
+}
+
+func handleUsersPresence(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	type P struct {
+		UsersIDs []string `validate:"required,min=1,max=100,unique,dive,uuid"`
+	}
+	payload := P{UsersIDs: strings.Split(c.Query("user_ids"), ",")}
+	if err := Validate(&payload); err != nil {
+		return err
+	}
+	out, err := GetUsersPresence(user, payload.UsersIDs)
+	if err != nil {
+		return err
+	}
+	return c.JSON(out)
+}
+
+func handleMarkRoomAsRead(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	type P struct {
+		MessageID uint `json:"message_id" validate:"required"`
+	}
+	var payload P
+	if err := ParseAndValidate(c, &payload); err != nil {
+		return err
+	}
+	out, err := MarkRoomAsRead(&MarkRoomAsReadInput{
+		U:         user,
+		RoomID:    c.Params("room_id"),
+		MessageID: payload.MessageID,
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(out)
+}
+
+func handleSetRoomMessageTTL(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	type P struct {
+		MessageTTL *int `json:"message_ttl"` // seconds, null turns the disappearing messages off
+	}
+	var payload P
+	if err := ParseAndValidate(c, &payload); err != nil {
+		return err
+	}
+	out, err := SetRoomMessageTTL(&SetRoomMessageTTLInput{
+		U:          user,
+		RoomID:     c.Params("room_id"),
+		TTLSeconds: payload.MessageTTL,
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(out)
+}
+
+func handleRoomReadReceipts(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	out, err := GetRoomReadReceipts(user, c.Params("room_id"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(out)
+}
+
+func handleRoomLiveLocations(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	out, err := GetRoomLiveLocations(user, c.Params("room_id"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(out)
+}
+
+func handleEditMessage(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	messageID, err := c.ParamsInt("message_id")
+	if err != nil || messageID <= 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid message_id")
+	}
+	type P struct {
+		Content string `json:"content" validate:"required,max=255"`
+	}
+	var payload P
+	if err := ParseAndValidate(c, &payload); err != nil {
+		return err
+	}
+	out, err := EditMessage(&EditMessageInput{
+		U:         user,
+		MessageID: uint(messageID),
+		Content:   payload.Content,
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(out)
+}
+
+func handleDeleteMessage(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	messageID, err := c.ParamsInt("message_id")
+	if err != nil || messageID <= 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid message_id")
+	}
+	out, err := DeleteMessage(&DeleteMessageInput{
+		U:         user,
+		MessageID: uint(messageID),
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(out)
+}
+
+func handleMessageEditHistory(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	messageID, err := c.ParamsInt("message_id")
+	if err != nil || messageID <= 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid message_id")
+	}
+	out, err := GetMessageEditHistory(user, uint(messageID))
+	if err != nil {
+		return err
+	}
+	return c.JSON(out)
+}
+
+func handleAddReaction(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	messageID, err := c.ParamsInt("message_id")
+	if err != nil || messageID <= 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid message_id")
+	}
+	type P struct {
+		Emoji string `json:"emoji" validate:"required,max=32"`
+	}
+	var payload P
+	if err := ParseAndValidate(c, &payload); err != nil {
+		return err
+	}
+	out, err := ReactToMessage(&ReactToMessageInput{
+		U:         user,
+		MessageID: uint(messageID),
+		Emoji:     payload.Emoji,
+		Action:    ReactionAdded,
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(out)
+}
+
+func handleRemoveReaction(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	messageID, err := c.ParamsInt("message_id")
+	if err != nil || messageID <= 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid message_id")
+	}
+	type P struct {
+		Emoji string `validate:"required,max=32"`
+	}
+	payload := P{Emoji: c.Query("emoji")}
+	if err := Validate(&payload); err != nil {
+		return err
+	}
+	out, err := ReactToMessage(&ReactToMessageInput{
+		U:         user,
+		MessageID: uint(messageID),
+		Emoji:     payload.Emoji,
+		Action:    ReactionRemoved,
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(out)
+}
+
+func handleMessageReplies(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	messageID, err := c.ParamsInt("message_id")
+	if err != nil || messageID <= 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid message_id")
+	}
+	out, err := GetMessageReplies(c, user, uint(messageID))
+	if err != nil {
+		return err
+	}
+	return c.JSON(out)
+}
+
+func handleVoiceNotePlayed(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	messageID, err := c.ParamsInt("message_id")
+	if err != nil || messageID <= 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid message_id")
+	}
+	out, err := MarkVoiceNotePlayed(user, uint(messageID))
+	if err != nil {
+		return err
+	}
+	return c.JSON(out)
+}
+
+func handleMyMentions(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	out, err := GetMyMentions(c, user)
+	if err != nil {
+		return err
+	}
+	return c.JSON(out)
+}
+
+func handleUploadAttachment(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	out, err := UploadAttachment(c, user)
+	if err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusCreated).JSON(out)
+}
+
+func handleDownloadAttachment(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	return DownloadAttachment(c, user, c.Params("attachment_id"))
+}
+
+func handleDownloadAttachmentThumbnail(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	return DownloadAttachmentThumbnail(c, user, c.Params("attachment_id"), c.Params("label"))
+}
+
+func handleTusOptions(c *fiber.Ctx) error {
+	return TusOptions(c)
+}
+
+func handleCreateUpload(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	return CreateUpload(c, user)
+}
+
+func handleUploadOffset(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	return GetUploadOffset(c, user, c.Params("upload_id"))
+}
+
+func handlePatchUpload(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	return PatchUpload(c, user, c.Params("upload_id"))
+}
+
+func handleTerminateUpload(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	return TerminateUpload(c, user, c.Params("upload_id"))
+}
+
+func handleSearchMessages(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	type P struct {
+		Query    string   `validate:"required,max=255"`
+		RoomID   string   `validate:"omitempty,uuid"`
+		SenderID string   `validate:"omitempty,uuid"`
+		Types    []string `validate:"unique,dive,oneof=text document image video audio location poll"`
+		Sort     string   `validate:"omitempty,oneof=newest relevance"`
+	}
+	payload := P{
+		Query:    strings.TrimSpace(c.Query("q")),
+		RoomID:   c.Query("room_id"),
+		SenderID: c.Query("sender_id"),
+		Types:    splitQueryList(c.Query("type")),
+		Sort:     c.Query("sort"),
+	}
+	if err := Validate(&payload); err != nil {
+		return err
+	}
+	from, err := parseSearchTime(c.Query("from"), false)
+	if err != nil {
+		return err
+	}
+	to, err := parseSearchTime(c.Query("to"), true)
+	if err != nil {
+		return err
+	}
+	out, err := SearchMessages(c, &SearchMessagesInput{
+		U:        user,
+		Query:    payload.Query,
+		RoomID:   payload.RoomID,
+		SenderID: payload.SenderID,
+		Types:    payload.Types,
+		From:     from,
+		To:       to,
+		Sort:     payload.Sort,
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(out)
+}
+
+func handleScheduleMessage(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	type P struct {
+		RoomID       string    `json:"room_id" validate:"required,uuid"`
+		Content      string    `json:"content" validate:"required_without=AttachmentID,max=255"`
+		ReplyToID    *uint     `json:"reply_to_id" validate:"omitempty,gt=0"`
+		AttachmentID string    `json:"attachment_id" validate:"omitempty,uuid"`
+		PlayOnce     bool      `json:"play_once"`
+		ScheduledAt  time.Time `json:"scheduled_at" validate:"required"`
+	}
+	var payload P
+	if err := ParseAndValidate(c, &payload); err != nil {
+		return err
+	}
+	out, err := ScheduleMessage(&ScheduleMessageInput{
+		U:            user,
+		RoomID:       payload.RoomID,
+		Content:      payload.Content,
+		ReplyToID:    payload.ReplyToID,
+		AttachmentID: payload.AttachmentID,
+		PlayOnce:     payload.PlayOnce,
+		ScheduledAt:  payload.ScheduledAt,
+	})
+	if err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusCreated).JSON(out)
+}
+
+func handleScheduledMessages(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	type P struct {
+		RoomID string `validate:"omitempty,uuid"`
+	}
+	payload := P{RoomID: c.Query("room_id")}
+	if err := Validate(&payload); err != nil {
+		return err
+	}
+	out, err := GetScheduledMessages(c, user, payload.RoomID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(out)
+}
+
+func handleUpdateScheduledMessage(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	scheduledMessageID, err := c.ParamsInt("scheduled_message_id")
+	if err != nil || scheduledMessageID <= 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid scheduled_message_id")
+	}
+	type P struct {
+		Content     *string    `json:"content" validate:"omitempty,max=255"`
+		ScheduledAt *time.Time `json:"scheduled_at" validate:"required_without=Content"`
+	}
+	var payload P
+	if err := ParseAndValidate(c, &payload); err != nil {
+		return err
+	}
+	out, err := UpdateScheduledMessage(&UpdateScheduledMessageInput{
 		U:           user,
-		OtherUserID: payload.OtherUserID,
-		RoomID:      payload.RoomID,
+		ID:          uint(scheduledMessageID),
 		Content:     payload.Content,
+		ScheduledAt: payload.ScheduledAt,
 	})
 	if err != nil {
 		return err
 	}
-	return c.JSON(fiber.Map{"message": "message sent"})
+	return c.JSON(out)
+}
+
+func handleCancelScheduledMessage(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	scheduledMessageID, err := c.ParamsInt("scheduled_message_id")
+	if err != nil || scheduledMessageID <= 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid scheduled_message_id")
+	}
+	if err := CancelScheduledMessage(user, uint(scheduledMessageID)); err != nil {
+		return err
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func handleVotePoll(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	messageID, err := c.ParamsInt("message_id")
+	if err != nil || messageID <= 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid message_id")
+	}
+	type P struct {
+		OptionIDs []uint `json:"option_ids" validate:"unique,dive,gt=0"` // empty to retract the votes
+	}
+	var payload P
+	if err := ParseAndValidate(c, &payload); err != nil {
+		return err
+	}
+	out, err := VotePoll(&VotePollInput{
+		U:         user,
+		MessageID: uint(messageID),
+		OptionIDs: payload.OptionIDs,
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(out)
+}
+
+func handleClosePoll(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	messageID, err := c.ParamsInt("message_id")
+	if err != nil || messageID <= 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid message_id")
+	}
+	out, err := ClosePoll(user, uint(messageID))
+	if err != nil {
+		return err
+	}
+	return c.JSON(out)
+}
+
+func handleForwardMessages(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	type P struct {
+		MessageIDs []uint   `json:"message_ids" validate:"required,unique,dive,gt=0"`
+		RoomIDs    []string `json:"room_ids" validate:"required,unique,dive,uuid"`
+	}
+	var payload P
+	if err := ParseAndValidate(c, &payload); err != nil {
+		return err
+	}
+	out, err := ForwardMessages(&ForwardMessagesInput{
+		U:           user,
+		MessagesIDs: payload.MessageIDs,
+		RoomsIDs:    payload.RoomIDs,
+	})
+	if err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"data": out})
+}
+
+func handleStarMessage(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	messageID, err := c.ParamsInt("message_id")
+	if err != nil || messageID <= 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid message_id")
+	}
+	type P struct {
+		Note *string `json:"note" validate:"omitempty,max=500"`
+	}
+	var payload P
+	// the body is optional, a message can be starred without a note
+	if len(c.Body()) > 0 {
+		if err := ParseAndValidate(c, &payload); err != nil {
+			return err
+		}
+	}
+	out, err := StarMessage(&StarMessageInput{
+		U:         user,
+		MessageID: uint(messageID),
+		Note:      payload.Note,
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(out)
+}
+
+func handleUnstarMessage(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	messageID, err := c.ParamsInt("message_id")
+	if err != nil || messageID <= 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid message_id")
+	}
+	if err := UnstarMessage(user, uint(messageID)); err != nil {
+		return err
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func handleStarredMessages(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	type P struct {
+		RoomID string `validate:"omitempty,uuid"`
+	}
+	payload := P{RoomID: c.Query("room_id")}
+	if err := Validate(&payload); err != nil {
+		return err
+	}
+	out, err := GetStarredMessages(c, user, payload.RoomID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(out)
+}
+
+func handleSaveDraft(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	type P struct {
+		Content string `json:"content" validate:"max=255"` // empty to clear the draft
+	}
+	var payload P
+	if err := ParseAndValidate(c, &payload); err != nil {
+		return err
+	}
+	out, err := SaveDraft(&SaveDraftInput{
+		U:       user,
+		RoomID:  c.Params("room_id"),
+		Content: payload.Content,
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(out)
+}
+
+func handleRoomPinnedMessages(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	out, err := GetRoomPinnedMessages(c, user, c.Params("room_id"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(out)
+}
+
+func handlePinMessage(c *fiber.Ctx) error {
+	return pinMessage(c, PinAdded)
+}
+
+func handleUnpinMessage(c *fiber.Ctx) error {
+	return pinMessage(c, PinRemoved)
+}
+
+func pinMessage(c *fiber.Ctx, action PinAction) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	messageID, err := c.ParamsInt("message_id")
+	if err != nil || messageID <= 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid message_id")
+	}
+	out, err := PinMessage(&PinMessageInput{
+		U:         user,
+		RoomID:    c.Params("room_id"),
+		MessageID: uint(messageID),
+		Action:    action,
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(out)
 }
@@ -45,3 +45,54 @@ func WSAuthMiddleware() fiber.Handler {
 		return c.Next()
 	}
 }
+
+// TusMiddleware sets the headers required by the tus protocol, and rejects
+// the requests of clients using another version of the protocol.
+func TusMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set("Tus-Resumable", TusVersion)
+		c.Set(fiber.HeaderAccessControlExposeHeaders,
+			"Location, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Upload-Offset, Upload-Length, Upload-Metadata, Upload-Expires, Attachment-Id")
+		if c.Method() != fiber.MethodOptions && c.Get("Tus-Resumable") != TusVersion {
+			c.Set("Tus-Version", TusVersion)
+			return fiber.NewError(fiber.StatusPreconditionFailed, "unsupported tus version, Tus-Resumable must be "+TusVersion)
+		}
+		return c.Next()
+	}
+}
+
+// BodyLimitMiddleware rejects the bodies larger than the limit of the route.
+// Only the uploads may be as large as maxUploadBodyLimit, the other routes are
+// limited to fiber.DefaultBodyLimit. The server streams the large bodies, so
+// they are rejected here before being read.
+func BodyLimitMiddleware() fiber.Handler {
+	uploadLimit := maxUploadBodyLimit()
+	return func(c *fiber.Ctx) error {
+		limit := fiber.DefaultBodyLimit
+		if isUploadRoute(c) {
+			limit = uploadLimit
+		}
+		var err error
+		switch length := c.Request().Header.ContentLength(); {
+		case length == -1:
+			// chunked bodies would be read whatever their size
+			err = fiber.NewError(fiber.StatusLengthRequired, "Content-Length header is required")
+		case length > limit:
+			err = fiber.ErrRequestEntityTooLarge
+		}
+		if err != nil {
+			// the body is left unread, the connection can not be reused
+			c.Context().SetConnectionClose()
+			return err
+		}
+		return c.Next()
+	}
+}
+
+func isUploadRoute(c *fiber.Ctx) bool {
+	switch c.Method() {
+	case fiber.MethodPost, fiber.MethodPatch:
+		return c.Path() == "/api/v1/chat/attachments" || strings.HasPrefix(c.Path(), "/api/v1/uploads")
+	}
+	return false
+}
@@ -26,14 +26,33 @@ const (
 
 	WSMessageEvent WSEventType = "message"
 	WSNewRoomEvent WSEventType = "new_room"
+	WSTypingEvent  WSEventType = "typing"
+	WSOnlineEvent  WSEventType = "online"
+	WSOfflineEvent WSEventType = "offline"
+	WSReadEvent    WSEventType = "read"
+	WSSessionEvent WSEventType = "session"
+	WSResyncEvent  WSEventType = "resync"
+
+	WSMessageEditedEvent  WSEventType = "message_edited"
+	WSMessageDeletedEvent WSEventType = "message_deleted"
+	WSReactionEvent       WSEventType = "reaction"
+	WSMentionEvent        WSEventType = "mention"
+	WSMessageUpdatedEvent WSEventType = "message_updated"
+	WSLiveLocationEvent   WSEventType = "live_location"
+	WSMessagePlayedEvent  WSEventType = "message_played"
+	WSRoomUpdatedEvent    WSEventType = "room_updated"
+	WSPollUpdatedEvent    WSEventType = "poll_updated"
+	WSPinEvent            WSEventType = "pin"
+	WSDraftUpdatedEvent   WSEventType = "draft_updated" // not replayed, the rooms list returns the drafts
 )
 
 type WSClientsPool struct {
-	connMutex sync.RWMutex
-	upgrader  websocket.Upgrader
-	clients   map[string][]*WSClientSocket
-	nextID    uint64
-	closeC    chan struct{}
+	connMutex   sync.RWMutex
+	upgrader    websocket.Upgrader
+	clients     map[string][]*WSClientSocket
+	nextID      uint64
+	nextEventID uint64
+	closeC      chan struct{}
 }
 
 func NewWSClientsPool() *WSClientsPool {
@@ -50,20 +69,24 @@ func NewWSClientsPool() *WSClientsPool {
 
 func (p *WSClientsPool) generateUniqueID() uint64 { return atomic.AddUint64(&p.nextID, 1) }
 
-// Add adds a new client to the pool, not thread-safe
-func (p *WSClientsPool) Add(key string, clientConnection *WSClientSocket) {
+// Add adds a new client to the pool, not thread-safe.
+// Returns true if this is the first client of the key (the user went online).
+func (p *WSClientsPool) Add(key string, clientConnection *WSClientSocket) (firstClient bool) {
 	clientConnection.id = p.generateUniqueID()
 	if _, exists := p.clients[key]; !exists {
 		p.clients[key] = make([]*WSClientSocket, 0)
+		firstClient = true
 	}
 	p.clients[key] = append(p.clients[key], clientConnection)
+	return
 }
 
-// Remove removes a client from the pool, not thread-safe
-func (p *WSClientsPool) Remove(key string, clientConnectionID uint64) {
+// Remove removes a client from the pool, not thread-safe.
+// Returns true if the removed client was the last one of the key (the user went offline).
+func (p *WSClientsPool) Remove(key string, clientConnectionID uint64) (lastClient bool) {
 	clients, exists := p.clients[key]
 	if !exists {
-		return
+		return false
 	}
 	for i, client := range clients {
 		if client.id == clientConnectionID {
@@ -74,7 +97,17 @@ func (p *WSClientsPool) Remove(key string, clientConnectionID uint64) {
 	// Remove key if no clients remain
 	if len(p.clients[key]) == 0 {
 		delete(p.clients, key)
+		return true
 	}
+	return false
+}
+
+// IsOnline returns true if the key has at least one connected client, thread-safe
+func (p *WSClientsPool) IsOnline(key string) bool {
+	p.connMutex.RLock()
+	defer p.connMutex.RUnlock()
+	_, exists := p.clients[key]
+	return exists
 }
 
 func (p *WSClientsPool) readPump(clientConn *WSClientSocket) {
@@ -112,7 +145,7 @@ func (p *WSClientsPool) readPump(clientConn *WSClientSocket) {
 			continue
 		}
 
-		if err := ReceiveWSEvent(clientConn.user, message); err != nil {
+		if err := ReceiveWSEvent(clientConn, message); err != nil {
 			logger.WithError(err).Error("failed to process message")
 		}
 
@@ -188,13 +221,20 @@ func (p *WSClientsPool) cleanupConnection(clientConn *WSClientSocket) {
 	p.connMutex.Lock()
 	close(clientConn.outQueue)
 	close(clientConn.closeC)
-	p.Remove(clientConn.userId, clientConn.id)
+	wentOffline := p.Remove(clientConn.userId, clientConn.id)
+	wsEventsLog.Touch(clientConn.userId)
 	p.connMutex.Unlock()
 	logger.Debugln("connection closed")
+
+	if wentOffline {
+		go UserWentOffline(clientConn.user)
+	}
 }
 
 // close closes all connections in the pool and cleans up
 func (p *WSClientsPool) close() {
+	// closed rather than sent to, so that every background loop of the pool stops
+	close(p.closeC)
 	// for _, clientConnections := range p.clients {
 	// 	for _, conn := range clientConnections {
 	// 		p.cleanupConnection(conn)
@@ -213,12 +253,20 @@ type WSClientSocket struct {
 	isWebBrowser bool
 	closeC       chan websocket.CloseError
 	forceCloseC  chan error
+	lastTypingAt map[string]time.Time // room id -> last typing_start, only accessed by the read pump
+	draftsMutex  sync.Mutex
+	drafts       map[string]*wsDraftState // room id -> draft throttling state
+	acksEnabled  bool                     // client opted in to acknowledge events, `ack=true` query param
+	pendingMutex sync.Mutex
+	pending      map[uint64]*pendingWSEvent // unacknowledged events by event id
 }
 
 type WSClientEventMessage struct {
+	EventID   uint64          `json:"event_id,omitempty"` // assigned by the pool, clients acknowledge it with an ack event
 	Type      WSEventType     `json:"type"`
 	Timestamp time.Time       `json:"timestamp"`
-	DataModel interface{}     `json:"-"` // this will be marshalled to json
+	Seq       uint64          `json:"seq,omitempty"` // per user sequence of replayable events, see WSEventsLog
+	DataModel interface{}     `json:"-"`             // this will be marshalled to json
 	Data      json.RawMessage `json:"data"`
 }
 
@@ -240,6 +288,13 @@ func (m *WSClientEventMessage) populateData() (err error) {
 }
 
 func HandleChatWS(w http.ResponseWriter, r *http.Request) {
+	// an invalid cursor would silently replay the whole log
+	lastSeq, err := parseLastSeq(r)
+	if err != nil {
+		http.Error(w, "invalid last_seq", http.StatusBadRequest)
+		return
+	}
+
 	conn, err := wsClientsPool.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		AppLogger.WithError(err).
@@ -257,19 +312,35 @@ func HandleChatWS(w http.ResponseWriter, r *http.Request) {
 	}
 
 	client := &WSClientSocket{
-		connection:  conn,
-		user:        user,
-		userId:      user.ID.String(),
-		outQueue:    make(chan WSClientEventMessage, 1),
-		closeC:      make(chan websocket.CloseError, 1),
-		pingMessage: make(chan []byte, 1),
-		forceCloseC: make(chan error, 1),
+		connection:   conn,
+		user:         user,
+		userId:       user.ID.String(),
+		outQueue:     make(chan WSClientEventMessage, wsOutQueueSize),
+		closeC:       make(chan websocket.CloseError, 1),
+		pingMessage:  make(chan []byte, 1),
+		forceCloseC:  make(chan error, 1),
+		lastTypingAt: make(map[string]time.Time),
+		drafts:       make(map[string]*wsDraftState),
+		acksEnabled:  r.URL.Query().Get("ack") == "true",
+		pending:      make(map[uint64]*pendingWSEvent),
 	}
 
+	// the missed events are collected while holding the pool lock, every later
+	// event is queued to the client directly, so nothing is lost or duplicated
 	wsClientsPool.connMutex.Lock()
-	wsClientsPool.Add(user.ID.String(), client)
+	wentOnline := wsClientsPool.Add(user.ID.String(), client)
+	snapshot := wsEventsLog.snapshot(client.userId, lastSeq)
 	wsClientsPool.connMutex.Unlock()
 
+	if wentOnline {
+		go UserWentOnline(user)
+	}
+
+	if err := wsClientsPool.resumeSession(r, client, snapshot); err != nil {
+		AppLogger.WithError(err).WithField("id", client.id).WithField("user_id", user.ID).Error("failed to resume ws session")
+		client.forceCloseC <- err
+	}
+
 	go wsClientsPool.readPump(client)
 	go wsClientsPool.writePump(client)
 
@@ -282,18 +353,14 @@ func BroadcastWSMassage(usersIDs []string, transformer func(userId string) WSCli
 			wsClientsPool.connMutex.RLock()
 			defer wsClientsPool.connMutex.RUnlock()
 
+			message := wsClientsPool.prepareUserEvent(userID, transformer(userID))
 			clients, exists := wsClientsPool.clients[userID]
 			if !exists {
 				AppLogger.WithField("user_id", userID).Warn("no clients found for user")
 				return
 			}
 			for _, client := range clients {
-				logger := AppLogger.WithField("id", client.id).WithField("user_id", client.userId)
-				select {
-				case client.outQueue <- transformer(userID):
-				default:
-					logger.Error("failed to send message to client")
-				}
+				wsClientsPool.deliver(client, message)
 			}
 		}()
 	}
@@ -310,18 +377,31 @@ func BroadcastMessageToWSClients(usersIDs []string, message WSClientEventMessage
 func SendMessageToWSClient(userID string, message WSClientEventMessage) error {
 	wsClientsPool.connMutex.RLock()
 	defer wsClientsPool.connMutex.RUnlock()
+	message = wsClientsPool.prepareUserEvent(userID, message)
 	clients, exists := wsClientsPool.clients[userID]
 	if !exists {
 		return fmt.Errorf("[websocket] no clients found for user %s", userID)
 	}
 	for _, client := range clients {
 		logger := AppLogger.WithField("id", client.id).WithField("user_id", client.userId)
-		select {
-		case client.outQueue <- message:
+		if wsClientsPool.deliver(client, message) {
 			logger.Debug("message sent to client")
-		default:
-			logger.Error("failed to send message to client")
 		}
 	}
 	return nil
 }
+
+// SendMessageToOtherWSClients sends the event to every connection of the user
+// except the one it originates from, 0 sends it to all of them. It keeps the
+// other devices of the user in sync with a change made on one of them.
+func SendMessageToOtherWSClients(userID string, exceptClientID uint64, message WSClientEventMessage) {
+	wsClientsPool.connMutex.RLock()
+	defer wsClientsPool.connMutex.RUnlock()
+	message = wsClientsPool.prepareUserEvent(userID, message)
+	for _, client := range wsClientsPool.clients[userID] {
+		if client.id == exceptClientID {
+			continue
+		}
+		wsClientsPool.deliver(client, message)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	wsOutQueueSize            = 32               // Buffered events per connection before they are left to the redelivery loop.
+	wsAckTimeout              = 10 * time.Second // Time a client has to acknowledge an event before it is re-sent.
+	wsRedeliveryInterval      = 2 * time.Second  // How often pending events are checked for redelivery.
+	wsMaxDeliveryAttempts     = 5                // Events are dropped after this many unacknowledged attempts.
+	wsMaxPendingPerConnection = 256              // Upper bound of tracked events per connection.
+)
+
+// wsEphemeralEvents are events that lose their meaning if delivered late,
+// they are never tracked for acknowledgement.
+var wsEphemeralEvents = map[WSEventType]bool{
+	WSTypingEvent:  true,
+	WSOnlineEvent:  true,
+	WSOfflineEvent: true,
+	WSSessionEvent: true,
+	WSResyncEvent:  true,
+
+	WSLiveLocationEvent: true,
+}
+
+func (t WSEventType) requiresAck() bool { return !wsEphemeralEvents[t] }
+
+type pendingWSEvent struct {
+	message       WSClientEventMessage
+	attempts      int
+	lastAttemptAt time.Time
+}
+
+func (p *WSClientsPool) generateEventID() uint64 { return atomic.AddUint64(&p.nextEventID, 1) }
+
+// prepareEvent assigns the server side fields of an event before it is delivered.
+func (p *WSClientsPool) prepareEvent(message WSClientEventMessage) WSClientEventMessage {
+	if message.Type.requiresAck() && message.EventID == 0 {
+		message.EventID = p.generateEventID()
+	}
+	if message.Timestamp.IsZero() {
+		message.Timestamp = time.Now()
+	}
+	return message
+}
+
+// deliver queues the event to the client and tracks it until it is acknowledged.
+// Must be called while holding at least the read lock of the pool.
+func (p *WSClientsPool) deliver(client *WSClientSocket, message WSClientEventMessage) bool {
+	logger := AppLogger.WithField("id", client.id).WithField("user_id", client.userId)
+
+	if !message.Type.requiresAck() {
+		select {
+		case client.outQueue <- message:
+			return true
+		default:
+			logger.Error("failed to send message to client")
+			return false
+		}
+	}
+
+	client.pendingMutex.Lock()
+	defer client.pendingMutex.Unlock()
+
+	pending := &pendingWSEvent{message: message}
+	if len(client.pending) < wsMaxPendingPerConnection {
+		client.pending[message.EventID] = pending
+	} else {
+		logger.WithField("event_id", message.EventID).Error("too many unacknowledged events, event will not be redelivered")
+	}
+	select {
+	case client.outQueue <- message:
+		pending.attempts++
+		pending.lastAttemptAt = time.Now()
+		if !client.acksEnabled {
+			// the client will never acknowledge, being queued is as far as we can track it
+			delete(client.pending, message.EventID)
+		}
+		return true
+	default:
+		logger.WithField("event_id", message.EventID).Warn("client queue is full, event queued for redelivery")
+		return false
+	}
+}
+
+// Ack stops tracking the acknowledged events of the client.
+func (c *WSClientSocket) Ack(eventsIDs ...uint64) {
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+	for _, id := range eventsIDs {
+		delete(c.pending, id)
+	}
+}
+
+// redeliveryLoop periodically re-sends the events that were either dropped
+// because the client queue was full or, for clients that opted in to
+// acknowledgements, not acknowledged in time.
+func (p *WSClientsPool) redeliveryLoop() {
+	ticker := time.NewTicker(wsRedeliveryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.closeC:
+			return
+		case <-ticker.C:
+			p.redeliverPending()
+		}
+	}
+}
+
+func (p *WSClientsPool) redeliverPending() {
+	p.connMutex.RLock()
+	defer p.connMutex.RUnlock()
+
+	now := time.Now()
+	for _, clients := range p.clients {
+		for _, client := range clients {
+			logger := AppLogger.WithField("id", client.id).WithField("user_id", client.userId)
+
+			client.pendingMutex.Lock()
+			// the events are re-sent in the order they were first sent
+			ids := make([]uint64, 0, len(client.pending))
+			for id := range client.pending {
+				ids = append(ids, id)
+			}
+			slices.Sort(ids)
+		resend:
+			for _, id := range ids {
+				pending := client.pending[id]
+				if pending.attempts > 0 && now.Sub(pending.lastAttemptAt) < wsAckTimeout {
+					continue
+				}
+				if pending.attempts >= wsMaxDeliveryAttempts {
+					logger.WithField("event_id", id).Error("event was not acknowledged, giving up")
+					delete(client.pending, id)
+					continue
+				}
+				select {
+				case client.outQueue <- pending.message:
+					pending.attempts++
+					pending.lastAttemptAt = now
+					if !client.acksEnabled {
+						delete(client.pending, id)
+					}
+				default:
+					// queue is still full, try again on the next tick, the
+					// later events wait so that they are not sent first
+					break resend
+				}
+			}
+			client.pendingMutex.Unlock()
+		}
+	}
+}
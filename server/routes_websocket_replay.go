@@ -0,0 +1,311 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsReplayLogSize       = 200              // Events kept per user for replay, older gaps require a full resync.
+	wsReplayLogIdleTTL    = 30 * time.Minute // Logs of the users without a connection are evicted once idle for this long.
+	wsReplayEvictInterval = time.Minute      // How often the idle logs are evicted.
+)
+
+var (
+	wsEventsLog = NewWSEventsLog()
+)
+
+// wsReplayableEvents are the events that are sequenced and kept in the per
+// user log, so they can be replayed after a reconnection.
+var wsReplayableEvents = map[WSEventType]bool{
+	WSMessageEvent: true,
+	WSNewRoomEvent: true,
+
+	WSMessageEditedEvent:  true,
+	WSMessageDeletedEvent: true,
+	WSMentionEvent:        true,
+	WSMessageUpdatedEvent: true,
+	WSRoomUpdatedEvent:    true,
+	WSPollUpdatedEvent:    true,
+	WSPinEvent:            true,
+}
+
+func (t WSEventType) isReplayable() bool { return wsReplayableEvents[t] }
+
+type WSSessionResource struct {
+	Epoch    string `json:"epoch"`
+	LastSeq  uint64 `json:"last_seq"`
+	Replayed int    `json:"replayed"`
+}
+
+type WSResyncResource struct {
+	Reason string `json:"reason"`
+}
+
+type wsUserEventLog struct {
+	lastSeq   uint64
+	events    []WSClientEventMessage // ordered by seq, at most wsReplayLogSize
+	touchedAt time.Time              // last event appended, or last connection opened or closed
+}
+
+// WSEventsLog is a bounded in-memory log of the replayable events of every user.
+// Sequence numbers are only meaningful within the same epoch, a new epoch is
+// started every time the server starts.
+type WSEventsLog struct {
+	mu         sync.Mutex
+	epoch      string
+	users      map[string]*wsUserEventLog
+	evictedSeq uint64 // the highest sequence number of the evicted logs
+}
+
+func NewWSEventsLog() *WSEventsLog {
+	return &WSEventsLog{
+		epoch: NewUUIDv4().String(),
+		users: make(map[string]*wsUserEventLog),
+	}
+}
+
+func (l *WSEventsLog) Epoch() string { return l.epoch }
+
+// Append assigns the next sequence number of the user to the event and stores it.
+func (l *WSEventsLog) Append(userID string, message WSClientEventMessage) WSClientEventMessage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	userLog, exists := l.users[userID]
+	if !exists {
+		// a log created again after an eviction must not reuse the sequence
+		// numbers the clients already saw, the older cursors are resynced
+		userLog = &wsUserEventLog{lastSeq: l.evictedSeq}
+		l.users[userID] = userLog
+	}
+	userLog.touchedAt = time.Now()
+	userLog.lastSeq++
+	message.Seq = userLog.lastSeq
+	userLog.events = append(userLog.events, message)
+	if len(userLog.events) > wsReplayLogSize {
+		userLog.events = userLog.events[len(userLog.events)-wsReplayLogSize:]
+	}
+	return message
+}
+
+// LastSeq returns the sequence number of the latest event of the user.
+func (l *WSEventsLog) LastSeq(userID string) uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if userLog, exists := l.users[userID]; exists {
+		return userLog.lastSeq
+	}
+	return 0
+}
+
+// Since returns the events of the user with a sequence number greater than
+// lastSeq. ok is false if some of these events are no longer in the log.
+func (l *WSEventsLog) Since(userID string, lastSeq uint64) (events []WSClientEventMessage, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	userLog, exists := l.users[userID]
+	if !exists {
+		return nil, lastSeq == 0
+	}
+	if lastSeq > userLog.lastSeq {
+		return nil, false
+	}
+	if lastSeq == userLog.lastSeq {
+		return nil, true
+	}
+	if len(userLog.events) == 0 || userLog.events[0].Seq > lastSeq+1 {
+		return nil, false
+	}
+	first := int(lastSeq + 1 - userLog.events[0].Seq)
+	events = make([]WSClientEventMessage, len(userLog.events)-first)
+	copy(events, userLog.events[first:])
+	return events, true
+}
+
+// Touch keeps the log of the user from being evicted, it is called when a
+// connection of the user is opened or closed.
+func (l *WSEventsLog) Touch(userID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if userLog, exists := l.users[userID]; exists {
+		userLog.touchedAt = time.Now()
+	}
+}
+
+// wsRedactableData is implemented by the data of the replayable events that
+// carry the content of messages.
+type wsRedactableData interface {
+	// redactMessages returns the data without the content of the messages,
+	// a MessageDeletedResource if the event is about one of them. ok is false
+	// if the data carries none of them.
+	redactMessages(messagesRooms map[uint]UUID) (data interface{}, ok bool)
+}
+
+// Redact removes the content of the messages from the logged events, the
+// events about them are replaced with a deletion so the sequence is kept.
+// messagesRooms maps the id of every message to the id of its room.
+func (l *WSEventsLog) Redact(messagesRooms map[uint]UUID) int {
+	if len(messagesRooms) == 0 {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	redacted := 0
+	for _, userLog := range l.users {
+		for i := range userLog.events {
+			data, ok := userLog.events[i].DataModel.(wsRedactableData)
+			if !ok {
+				continue
+			}
+			redactedData, ok := data.redactMessages(messagesRooms)
+			if !ok {
+				continue
+			}
+			if _, deleted := redactedData.(MessageDeletedResource); deleted {
+				userLog.events[i].Type = WSMessageDeletedEvent
+			}
+			userLog.events[i].DataModel = redactedData
+			userLog.events[i].Data = nil
+			redacted++
+		}
+	}
+	return redacted
+}
+
+// evictIdle drops the logs of the users that have no connection and were not
+// touched for wsReplayLogIdleTTL. Must be called while holding at least the
+// read lock of the pool.
+func (l *WSEventsLog) evictIdle(p *WSClientsPool) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	evicted := 0
+	for userID, userLog := range l.users {
+		if len(p.clients[userID]) > 0 || time.Since(userLog.touchedAt) < wsReplayLogIdleTTL {
+			continue
+		}
+		if userLog.lastSeq > l.evictedSeq {
+			l.evictedSeq = userLog.lastSeq
+		}
+		delete(l.users, userID)
+		evicted++
+	}
+	return evicted
+}
+
+// replayLogEvictionLoop periodically frees the replay logs of the users who
+// left, their clients get a resync event when they come back.
+func (p *WSClientsPool) replayLogEvictionLoop() {
+	ticker := time.NewTicker(wsReplayEvictInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.closeC:
+			return
+		case <-ticker.C:
+			p.connMutex.RLock()
+			evicted := wsEventsLog.evictIdle(p)
+			p.connMutex.RUnlock()
+			if evicted > 0 {
+				AppLogger.WithField("count", evicted).Debug("evicted idle replay logs")
+			}
+		}
+	}
+}
+
+// prepareUserEvent prepares the event for a specific user, sequencing and
+// logging it if it is replayable. The event is logged even if the user has no
+// connected clients.
+func (p *WSClientsPool) prepareUserEvent(userID string, message WSClientEventMessage) WSClientEventMessage {
+	message = p.prepareEvent(message)
+	if message.Type.isReplayable() {
+		message = wsEventsLog.Append(userID, message)
+	}
+	return message
+}
+
+// wsSessionSnapshot is the state of the user log taken while the client is
+// added to the pool, every later event is queued to the client directly.
+type wsSessionSnapshot struct {
+	lastSeq  uint64
+	replay   []WSClientEventMessage
+	replayOk bool
+}
+
+func (l *WSEventsLog) snapshot(userID string, lastSeq uint64) wsSessionSnapshot {
+	l.Touch(userID)
+	replay, ok := l.Since(userID, lastSeq)
+	return wsSessionSnapshot{
+		lastSeq:  l.LastSeq(userID),
+		replay:   replay,
+		replayOk: ok,
+	}
+}
+
+// resumeSession is called with a newly added client before its pumps are
+// started. It sends the session event followed by either the events missed
+// since the `last_seq` query param or a resync event.
+func (p *WSClientsPool) resumeSession(r *http.Request, clientConn *WSClientSocket, snapshot wsSessionSnapshot) error {
+	replay := snapshot.replay
+	session := WSSessionResource{Epoch: wsEventsLog.Epoch(), LastSeq: snapshot.lastSeq}
+
+	var resync *WSResyncResource
+	if r.URL.Query().Has("last_seq") {
+		if epoch := r.URL.Query().Get("epoch"); epoch != "" && epoch != wsEventsLog.Epoch() {
+			resync = &WSResyncResource{Reason: "session epoch changed"}
+		} else if !snapshot.replayOk {
+			resync = &WSResyncResource{Reason: "too many missed events"}
+		} else {
+			session.Replayed = len(replay)
+		}
+	}
+
+	if err := p.writeDirect(clientConn, WSClientEventMessage{Type: WSSessionEvent, DataModel: session}); err != nil {
+		return err
+	}
+	if resync != nil {
+		return p.writeDirect(clientConn, WSClientEventMessage{Type: WSResyncEvent, DataModel: *resync})
+	}
+	if session.Replayed == 0 {
+		return nil
+	}
+	for _, message := range replay {
+		if clientConn.acksEnabled {
+			clientConn.pendingMutex.Lock()
+			clientConn.pending[message.EventID] = &pendingWSEvent{
+				message:       message,
+				attempts:      1,
+				lastAttemptAt: time.Now(),
+			}
+			clientConn.pendingMutex.Unlock()
+		}
+		if err := p.writeDirect(clientConn, message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeDirect writes the event to the connection bypassing the output queue,
+// only safe before the write pump of the client is started.
+func (p *WSClientsPool) writeDirect(clientConn *WSClientSocket, message WSClientEventMessage) error {
+	message = p.prepareEvent(message)
+	data, err := message.JSONMarshal()
+	if err != nil {
+		return err
+	}
+	clientConn.connection.SetWriteDeadline(time.Now().Add(writeWait))
+	return clientConn.connection.WriteMessage(websocket.TextMessage, data)
+}
+
+// parseLastSeq parses the `last_seq` query param, a missing value is treated
+// as 0.
+func parseLastSeq(r *http.Request) (uint64, error) {
+	if !r.URL.Query().Has("last_seq") {
+		return 0, nil
+	}
+	return strconv.ParseUint(r.URL.Query().Get("last_seq"), 10, 64)
+}
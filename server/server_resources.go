@@ -18,10 +18,15 @@ type ChatRoomResource struct {
 	NumberOfUsers *int     `json:"number_of_users,omitempty"`
 	Users         []User   `json:"users,omitempty"`
 	UserIDs       []string `json:"user_ids,omitempty"`
+	AdminIDs      []string `json:"admin_ids,omitempty"`
 
 	OtherUser *User `json:"other_user,omitempty"`
 
 	LastMessage *SentMessageResource `json:"last_message"`
+	UnreadCount *int                 `json:"unread_count,omitempty"`
+	MessageTTL  *int                 `json:"message_ttl,omitempty"` // seconds, the messages of the room disappear after it
+	PinnedCount *int                 `json:"pinned_count,omitempty"`
+	Draft       *DraftResource       `json:"draft,omitempty"` // the message the user started writing in the room
 }
 
 type SentMessageResource struct {
@@ -34,4 +39,45 @@ type SentMessageResource struct {
 	SenderID  *UUID      `json:"sender_id,omitempty"`
 	RoomID    *UUID      `json:"room_id,omitempty"`
 	User      User       `json:"sent_by"`
+
+	ReplyToID    *uint                   `json:"reply_to_id,omitempty"`
+	ReplyTo      *MessagePreviewResource `json:"reply_to,omitempty"`       // only set if the parent is loaded and not deleted
+	ThreadRootID *uint                   `json:"thread_root_id,omitempty"` // the message holding the reply counters of the thread
+	ReplyCount   int                     `json:"reply_count,omitempty"`
+	LastReplyAt  *time.Time              `json:"last_reply_at,omitempty"`
+
+	Attachment *AttachmentResource `json:"attachment,omitempty"`
+	Location   *LocationResource   `json:"location,omitempty"`
+	Poll       *PollResource       `json:"poll,omitempty"`
+	PlayOnce   bool                `json:"play_once,omitempty"`
+	PlayedBy   []UUID              `json:"played_by,omitempty"`  // only for voice notes
+	ExpiresAt  *time.Time          `json:"expires_at,omitempty"` // only for disappearing messages
+	Starred    bool                `json:"starred"`              // by the viewer
+
+	SystemEvent     *SystemEvent `json:"system_event,omitempty"` // only for the system messages
+	TargetMessageID *uint        `json:"target_message_id,omitempty"`
+
+	ForwardedFrom *ForwardedFromResource `json:"forwarded_from,omitempty"` // only for the forwarded messages
+
+	Reactions []ReactionSummaryResource `json:"reactions,omitempty"`
+	Mentions  []MentionResource         `json:"mentions,omitempty"`
+}
+
+// ForwardedFromResource points to where a forwarded message was first sent,
+// the ids are null once the original rows are deleted.
+type ForwardedFromResource struct {
+	MessageID  *uint  `json:"message_id"`
+	SenderID   *UUID  `json:"sender_id"`
+	SenderName string `json:"sender_name,omitempty"`
+	RoomID     *UUID  `json:"room_id"`
+}
+
+// MessagePreviewResource is a compact version of a message, embedded in
+// the messages that reference it.
+type MessagePreviewResource struct {
+	ID         uint   `json:"id"`
+	Content    string `json:"content"`
+	Type       CMType `json:"type"`
+	SenderID   UUID   `json:"sender_id"`
+	SenderName string `json:"sender_name,omitempty"`
 }
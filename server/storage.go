@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+)
+
+var (
+	// Do not use this in production code. Use Storage() instead.
+	_storage StorageBackend
+
+	ErrObjectNotFound = errors.New("object not found")
+)
+
+// StorageBackend stores the uploaded files, keys are generated by the server.
+type StorageBackend interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+func Storage() StorageBackend { return _storage }
+
+// OpenStorage opens the backend selected by the STORAGE_DRIVER env variable,
+// `local` (default) or `s3`.
+func OpenStorage() error {
+	if _storage != nil {
+		return nil
+	}
+	switch driver := strings.ToLower(GetenvDef("STORAGE_DRIVER", "local")); driver {
+	case "local":
+		s, err := NewLocalStorage(GetenvDef("STORAGE_LOCAL_DIR", "./storage"))
+		if err != nil {
+			return err
+		}
+		_storage = s
+	case "s3":
+		s, err := NewS3Storage(&S3StorageConfig{
+			Endpoint:  GetenvDef("S3_ENDPOINT", "localhost:9000"),
+			AccessKey: GetenvDef("S3_ACCESS_KEY", ""),
+			SecretKey: GetenvDef("S3_SECRET_KEY", ""),
+			Bucket:    GetenvDef("S3_BUCKET", "spock"),
+			Region:    GetenvDef("S3_REGION", ""),
+			UseSSL:    strings.ToLower(GetenvDef("S3_USE_SSL", "false")) == "true",
+		})
+		if err != nil {
+			return err
+		}
+		_storage = s
+	default:
+		return errors.New("unknown STORAGE_DRIVER: " + driver)
+	}
+	return nil
+}
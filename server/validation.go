@@ -40,6 +40,14 @@ func ParseAndValidate(c *fiber.Ctx, target any) error {
 	if err := c.BodyParser(target); err != nil {
 		return c.Status(http.StatusUnprocessableEntity).JSON(err)
 	}
+	return Validate(target)
+}
+
+// Validate validates an already populated struct, e.g. one built from query params.
+func Validate(target any) error {
+	if target == nil {
+		return errors.New("validation target is nil")
+	}
 	err := vdr.Struct(target)
 	if err == nil {
 		return nil